@@ -8,8 +8,14 @@ package figtree
 
 // The Branch type is a slice of configuration tree items, in insertion order, where items
 // are either key/value pairs or key/branch pairs. Branches form a hierarchical tree of items.
+//
+// The lineEnding field of a root branch is the line ending of the file it was read from,
+// either "\n" or "\r\n", so that WriteFigtree can reproduce it. It is empty for other branches.
+// The metadata field of a root branch holds the values recorded by the handlers of registered pragmas.
 type Branch struct {
-	Items []Item
+	Items      []Item
+	lineEnding string
+	metadata   map[string]string
 }
 
 // The NewBranch function is used to create a branch that will be used
@@ -25,6 +31,3 @@ func NewBranch() *Branch {
 func (branch *Branch) ItemCount() int {
 	return len(branch.Items)
 }
-
-var gBaselineTree *Branch // pointer to the tree of items built by the !baseline pragma, if any
-var gDtdTree *Branch      // pointer to the tree of items built by the !dtd pragma, if any
@@ -0,0 +1,142 @@
+//=============================================================================
+// File:     conditional.go
+// Contents: readConditional handles the !if and !else pragmas
+//           isConditionalBlock
+//           evaluateCondition
+//=============================================================================
+
+package figtree
+
+import (
+	"strings"
+)
+
+// Special processing for the !if and !else pragmas, which open a conditional block.
+// The BeginBranch event of an !if pragma has the condition as its value; an !else pragma
+// has no value, and must immediately follow the closing brace of an !if block.
+//
+// The block is kept in the tree as a branch item, whose key is the pragma and its condition,
+// so that WriteFigtree can reproduce it. When the block is taken, a copy of each of its items is
+// also appended to the current branch, where it can be accessed like any other item. The copies
+// are the ones that WriteFigtree writes within the block, so that changes to them are kept.
+// Pragmas within a block that is not taken are kept, but not acted upon.
+//
+// The normal return is the sentinal ErrEndOfBranch, as with handleBranch.
+func (branch *Branch) readConditional(ctx *readContext, parser *EventParser, event Event, blockComments []string, srcOrigin FileOrigin) error {
+	pragma := event.Key
+	condition := event.Value
+	key := pragma
+	taken := false
+	ok := true
+
+	var reason ParseReason
+	if pragma == "!if" {
+		key = "!if " + condition
+		reason = ReasonMalformedCondition
+		taken, ok = ctx.evaluateCondition(condition)
+	} else {
+		ifCondition, found := branch.precedingCondition()
+		switch {
+		case condition != "":
+			ok, reason = false, ReasonMalformedCondition
+		case !found:
+			ok, reason = false, ReasonElseWithoutIf
+		default:
+			ifTaken, wellFormed := ctx.evaluateCondition(ifCondition)
+			taken = wellFormed && !ifTaken
+		}
+	}
+	if !ok {
+		// the block is still read, so that its closing brace is matched, but it is never taken
+		parseErr := newParseError(event.SrcFile, event.SrcLine, event.LineText, pragma, reason)
+		parseErr.Key = key
+		err := ctx.report(parseErr, event.SrcFile, event.SrcLine, event.LineText)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a block within a block that is not taken is never taken either
+	taken = taken && ctx.untakenDepth == 0
+	if !taken {
+		ctx.untakenDepth++
+		defer func() {
+			ctx.untakenDepth--
+		}()
+	}
+
+	first := len(branch.Items)
+	err := branch.handleBranch(ctx, parser, event, key, blockComments, srcOrigin)
+	if err != ErrEndOfBranch || !taken {
+		return err
+	}
+	branch.Items[first].conditionalTaken = true
+	innerBranch := branch.Items[first].value.(*Branch)
+	for _, item := range innerBranch.Items {
+		// the copy of an item within a nested block that is taken stays with that block
+		item = item.Copy()
+		if item.conditional == nil {
+			item.conditional = innerBranch
+		}
+		branch.Items = append(branch.Items, item)
+	}
+	return ErrEndOfBranch
+}
+
+// Determine whether the item is an !if or !else block.
+func isConditionalBlock(item Item) bool {
+	_, isBranch := item.value.(*Branch)
+	return isBranch && (strings.HasPrefix(item.key, "!if ") || item.key == "!else")
+}
+
+// Find the condition of the !if block that ends with the last item written to this branch.
+//
+// Returns false when the last item, ignoring copies of a taken block's items, is not an !if block.
+func (branch *Branch) precedingCondition() (string, bool) {
+	for i := len(branch.Items) - 1; i >= 0; i-- {
+		item := branch.Items[i]
+		if item.conditional != nil {
+			continue
+		}
+		if _, isBranch := item.value.(*Branch); isBranch && strings.HasPrefix(item.key, "!if ") {
+			return strings.TrimPrefix(item.key, "!if "), true
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// Evaluate the condition of an !if pragma against the variables passed with WithVariables.
+// The recognized forms are:
+//
+//	name == value    true when the variable has the given value
+//	name != value    true when the variable does not have the given value
+//	name             true when the variable has a value other than an empty string
+//
+// A variable that was not passed has the value of an empty string.
+//
+// Returns false as the second value when the condition is malformed.
+func (ctx *readContext) evaluateCondition(condition string) (taken bool, ok bool) {
+	op, operator := -1, ""
+	for _, candidate := range []string{"==", "!="} {
+		if i := strings.Index(condition, candidate); i != -1 && (op == -1 || i < op) {
+			op, operator = i, candidate
+		}
+	}
+	if operator == "" {
+		if !isIdentifier(condition) {
+			return false, false
+		}
+		return ctx.variables[condition] != "", true
+	}
+
+	name := strings.Trim(condition[:op], " \t")
+	value := strings.Trim(condition[op+len(operator):], " \t")
+	if !isIdentifier(name) || value == "" {
+		return false, false
+	}
+	if operator == "==" {
+		return ctx.variables[name] == value, true
+	}
+	return ctx.variables[name] != value, true
+}
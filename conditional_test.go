@@ -0,0 +1,141 @@
+//=============================================================================
+// File:     conditional_test.go
+// Tests:    !if and !else blocks evaluated against WithVariables
+//           Pragmas within a block that is not taken
+//           WriteFigtree reproduces the conditional blocks
+//           WriteFigtree keeps changes to a taken block's items, and WriteJson omits the blocks
+//           Malformed conditions and !else without !if
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/readwritepro/figtree"
+)
+
+const conditionalInput = `host example.com
+!if env == production {
+	port 443
+	!if region != eu {
+		cdn global
+	}
+}
+!else {
+	port 8080
+}
+!if debug {
+	verbose true
+	!include missing.fig
+}
+`
+
+func TestConditionalBlocks(t *testing.T) {
+	production := map[string]string{"env": "production", "region": "us"}
+	root, err := figtree.ParseString(conditionalInput, figtree.WithVariables(production))
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	tests := map[string]string{
+		"host": "example.com",
+		"port": "443",
+		"cdn":  "global",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", keyPath, expected, actual, err)
+		}
+	}
+	if root.ItemExists("verbose") {
+		t.Errorf("expected 'verbose' to be dropped")
+	}
+
+	// the !include within the block that is not taken is never read
+	staging := map[string]string{"env": "staging"}
+	root, err = figtree.ParseString(conditionalInput, figtree.WithVariables(staging))
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	actual, _ := root.GetValue("port")
+	expected := "8080"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+	if root.ItemExists("cdn") || root.ItemExists("verbose") {
+		t.Errorf("expected 'cdn' and 'verbose' to be dropped")
+	}
+}
+
+func TestConditionalWriteFigtree(t *testing.T) {
+	root, err := figtree.ParseString(conditionalInput, figtree.WithVariables(map[string]string{"env": "production"}))
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	wf := figtree.WriteFigtree{}
+	actual, _ := root.WriteToBuffer(wf)
+	if conditionalInput != actual {
+		t.Errorf("expected\n%s\ngot\n%s", conditionalInput, actual)
+	}
+}
+
+func TestConditionalAlterThenWrite(t *testing.T) {
+	production := map[string]string{"env": "production", "region": "us"}
+	root, err := figtree.ParseString(conditionalInput, figtree.WithVariables(production))
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	item, _ := root.GetItem("port")
+	item.SetValue("444")
+	item, _ = root.GetItem("cdn")
+	item.SetValue("regional")
+
+	wf := figtree.WriteFigtree{}
+	actual, _ := root.WriteToBuffer(wf)
+	expected := strings.Replace(strings.Replace(conditionalInput, "port 443", "port 444", 1), "cdn global", "cdn regional", 1)
+	if expected != actual {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+
+	// the changes survive being read back
+	reread, err := figtree.ParseString(actual, figtree.WithVariables(production))
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	if port, _ := reread.GetValue("port"); port != "444" {
+		t.Errorf("expected '444', got '%s'", port)
+	}
+
+	// the blocks, and the values of the blocks that are not taken, are not written as JSON or YAML
+	for _, wc := range []figtree.WriteConfig{figtree.WriteJson{}, figtree.WriteYaml{}} {
+		actual, _ = root.WriteToBuffer(wc)
+		if strings.Contains(actual, "!if") || strings.Contains(actual, "!else") || strings.Contains(actual, "8080") {
+			t.Errorf("expected no conditional blocks, got\n%s", actual)
+		}
+		if !strings.Contains(actual, "444") || !strings.Contains(actual, "regional") {
+			t.Errorf("expected the taken values, got\n%s", actual)
+		}
+	}
+}
+
+func TestMalformedConditions(t *testing.T) {
+	tests := map[string]figtree.ParseReason{
+		"!if env = production {\n}\n":    figtree.ReasonMalformedCondition,
+		"!if env ==\n":                   figtree.ReasonMalformedCondition,
+		"key value\n!else {\n}\n":        figtree.ReasonElseWithoutIf,
+		"!if env {\n}\n!else env {\n}\n": figtree.ReasonMalformedCondition,
+	}
+	for input, expected := range tests {
+		_, err := figtree.ParseString(input)
+		var parseErr *figtree.ParseError
+		if !errors.As(err, &parseErr) || expected != parseErr.Reason {
+			t.Errorf("%q: expected '%v', got '%v'", input, expected, err)
+		}
+		if !errors.Is(err, figtree.ErrSyntax) {
+			t.Errorf("%q: expected '%v', got '%v'", input, figtree.ErrSyntax, err)
+		}
+	}
+}
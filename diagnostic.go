@@ -0,0 +1,99 @@
+//=============================================================================
+// File:     diagnostic.go
+// Contents: Severity enum declaration
+//           Diagnostic type declaration
+//           ReadConfigTolerant, ReadFigtreeFromTolerant
+//=============================================================================
+
+package figtree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The Severity type distinguishes problems that prevent a line from being
+// understood from those that are merely suspicious.
+type Severity int
+
+const (
+	SeverityError   Severity = iota // the line, or the brace it contains, was discarded
+	SeverityWarning                 // the line was accepted, but probably not as intended
+)
+
+func (severity Severity) String() string {
+	return [...]string{"error", "warning"}[severity]
+}
+
+// The Diagnostic type describes one problem found by a tolerant read.
+// The Err field is usually a *ParseError, but may be any error raised while
+// reading a file referenced by a pragma, such as a missing !include file.
+type Diagnostic struct {
+	Severity Severity
+	SrcFile  string // the name of the file containing the problem
+	SrcLine  int    // the 1-based line number of the problem
+	Column   int    // the 1-based column of the problem
+	Err      error
+}
+
+// Formats the diagnostic as "srcFile:srcLine:column: severity: message".
+func (d Diagnostic) String() string {
+	var msg string
+	var parseErr *ParseError
+	if errors.As(d.Err, &parseErr) {
+		msg = parseErr.message()
+	} else {
+		msg = d.Err.Error()
+	}
+	return fmt.Sprintf("%s:%d:%d: %v: %s", d.SrcFile, d.SrcLine, d.Column, d.Severity, msg)
+}
+
+// The ReadConfigTolerant function reads a user's configuration file, in the
+// same way as ReadConfig, but rather than stopping at the first problem, it
+// resyncs after each bad line or brace and continues to the end of the file.
+//
+// Returns as much of the tree as could be built, together with every problem
+// found in the user's file and in the files it references. The tree is nil when
+// reading could not continue, because the user's file could not be opened, a file could
+// not be read, or a limit set by an option, such as WithMaxItems, was exceeded.
+func ReadConfigTolerant(inFilename string, options ...ReadOption) (*Branch, []Diagnostic) {
+	return NewReader(options...).ReadConfigTolerant(inFilename)
+}
+
+// The ReadFigtreeFromTolerant function parses figtree syntax from the given reader,
+// in the same way as ReadFigtreeFrom, but collects every problem rather than
+// stopping at the first one. It is intended for editors and lint tools.
+func ReadFigtreeFromTolerant(r io.Reader, srcFile string, options ...ReadOption) (*Branch, []Diagnostic) {
+	return NewReader(options...).ReadFigtreeFromTolerant(r, srcFile)
+}
+
+// Record an error found while parsing the given line.
+// When the read is tolerant the error is added to the list of diagnostics and nil is returned,
+// allowing the parser to resync; otherwise the error is returned unchanged to halt the parser.
+func (ctx *readContext) report(err error, srcFile string, srcLine int, lineText string) error {
+	if !ctx.tolerant {
+		return err
+	}
+	diagnostic := Diagnostic{
+		Severity: SeverityError,
+		SrcFile:  srcFile,
+		SrcLine:  srcLine,
+		Column:   len(lineText) - len(strings.TrimLeft(lineText, " \t")) + 1,
+		Err:      err,
+	}
+	var parseErr *ParseError
+	var limitErr *LimitError
+	if errors.As(err, &parseErr) {
+		diagnostic.SrcFile = parseErr.SrcFile
+		diagnostic.SrcLine = parseErr.SrcLine
+		diagnostic.Column = parseErr.Column
+	} else if errors.As(err, &limitErr) && limitErr.SrcLine > 0 {
+		diagnostic.SrcFile = limitErr.SrcFile
+		diagnostic.SrcLine = limitErr.SrcLine
+		diagnostic.Column = 1
+	}
+	ctx.diagnostics = append(ctx.diagnostics, diagnostic)
+	return nil
+}
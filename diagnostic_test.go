@@ -0,0 +1,102 @@
+//=============================================================================
+// File:     diagnostic_test.go
+// Tests:    ReadFigtreeFromTolerant collects every problem
+//           ReadConfigTolerant with a missing file
+//           ReadConfigTolerant with an exceeded limit and an unknown profile
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/readwritepro/figtree"
+)
+
+func TestReadFigtreeFromTolerant(t *testing.T) {
+	input := strings.Join([]string{
+		"key1 value1",
+		"}",
+		"!include testdata/fixtures/missing-include",
+		"section1 {",
+		"\tkey2 value2",
+		"}",
+		"}",
+		"section2 {",
+		"\tkey3 value3",
+	}, "\n")
+	root, diagnostics := figtree.ReadFigtreeFromTolerant(strings.NewReader(input), "tolerant")
+	if root == nil {
+		t.Fatalf("expected a partial tree, got 'nil'")
+	}
+
+	expected := []string{
+		"tolerant:2:1: error: unexpected closing brace",
+		"tolerant:3:1: error: ",
+		"tolerant:7:1: error: unexpected closing brace",
+		"tolerant:8:10: error: unclosed opening brace for 'section2'",
+	}
+	if len(expected) != len(diagnostics) {
+		t.Fatalf("expected %d diagnostics, got %d: %v", len(expected), len(diagnostics), diagnostics)
+	}
+	for i, diagnostic := range diagnostics {
+		if !strings.HasPrefix(diagnostic.String(), expected[i]) {
+			t.Errorf("expected '%s', got '%s'", expected[i], diagnostic.String())
+		}
+		if diagnostic.Severity != figtree.SeverityError {
+			t.Errorf("expected '%v', got '%v'", figtree.SeverityError, diagnostic.Severity)
+		}
+	}
+
+	// the wording of the missing include's error depends upon the operating system
+	if !errors.Is(diagnostics[1].Err, fs.ErrNotExist) {
+		t.Errorf("expected '%v', got '%v'", fs.ErrNotExist, diagnostics[1].Err)
+	}
+
+	// everything that could be parsed is in the tree
+	for _, keyPath := range []string{"key1", "section1/key2", "section2/key3"} {
+		if !root.PathExists(keyPath) {
+			t.Errorf("expected '%s' to exist", keyPath)
+		}
+	}
+}
+
+func TestReadConfigTolerantMissingFile(t *testing.T) {
+	inFilename := "testdata/fixtures/missing-config"
+	root, diagnostics := figtree.ReadConfigTolerant(inFilename)
+	if root != nil {
+		t.Errorf("expected 'nil', got '%v'", root)
+	}
+	if len(diagnostics) != 1 {
+		t.Errorf("expected 1 diagnostic, got %d", len(diagnostics))
+	}
+}
+
+func TestReadConfigTolerantStopped(t *testing.T) {
+	inFilename := "testdata/fixtures/sample"
+
+	// a limit stops the read, and is reported at the line where it was exceeded
+	root, diagnostics := figtree.ReadConfigTolerant(inFilename, figtree.WithMaxItems(5))
+	if root != nil {
+		t.Errorf("expected 'nil', got '%v'", root)
+	}
+	var limitErr *figtree.LimitError
+	if len(diagnostics) != 1 || !errors.As(diagnostics[0].Err, &limitErr) {
+		t.Fatalf("expected a *LimitError, got %v", diagnostics)
+	}
+	if diagnostics[0].SrcFile != inFilename || diagnostics[0].SrcLine != limitErr.SrcLine || limitErr.SrcLine == 0 {
+		t.Errorf("expected the position of the limit, got '%v'", diagnostics[0])
+	}
+
+	// a profile that is not found leaves the tree intact
+	root, diagnostics = figtree.ReadConfigTolerant(inFilename, figtree.WithProfiles("missing"))
+	if root == nil {
+		t.Fatalf("expected a tree, got 'nil'")
+	}
+	if len(diagnostics) != 1 || !errors.Is(diagnostics[0].Err, figtree.ErrProfileNotFound) {
+		t.Errorf("expected '%v', got %v", figtree.ErrProfileNotFound, diagnostics)
+	}
+}
@@ -48,6 +48,28 @@
 //  version     1.0
 //  note        A fig tree should not be confused with a "figtree"
 //
+// When a value needs leading or trailing whitespace, a hashtag preceded by whitespace,
+// or a line break, it may be delimited by quotation marks, provided that the file is read
+// with the WithQuotedValues option. Within the quotes, the escape sequences \" \\ \n \r \t
+// and \uXXXX are recognized. With the option, a value that begins with a quotation mark is
+// always parsed this way, so a literal leading quotation mark must be escaped. Without it,
+// quotation marks are an ordinary part of the value. WriteFigtree adds the quotes whenever
+// they are needed when its QuotedValues field is true. Examples:
+//
+//  indent      "    four spaces"
+//  channel     "#general"                # a value that begins with a hashtag
+//  greeting    "Hello,\n\"figtree\""
+//
+// Values that span multiple lines, such as certificates and scripts, may be written
+// as a heredoc. The lines following the key, up to a line containing only the chosen
+// delimiter, are kept verbatim. With the <<- form, the whitespace preceding the closing
+// delimiter is stripped from the beginning of every line. Example:
+//
+//  query <<-SQL
+//      SELECT name
+//        FROM users
+//      SQL
+//
 // The second construct to understand are named sections, which are multi-line
 // collections of key/value pairs. Named sections have a key name followed by
 // a K&R-style pair of curly braces.
@@ -80,6 +102,26 @@
 //      ns ns3.figtree.net
 //  }
 //
+// Leaf values may refer to environment variables, which are expanded as the file is read
+// when the WithEnvExpansion option is given. The forms ${NAME}, ${NAME:-default} and
+// ${NAME:?message} are recognized, and $$ is a literal dollar sign. Heredoc values are never
+// expanded. The unexpanded text is kept, available through RawValue, and written back out by
+// WriteFigtree, which escapes the dollar signs of a value set with SetValue when its
+// EnvExpansion field is true. Without the option, values are kept exactly as written. Example:
+//
+//  port        ${PORT:-8080}
+//
+// A reference containing a slash is a keyPath to another item in the tree, and a keyPath
+// beginning with a slash refers to an item at the root. With the same option, ReadConfig
+// resolves these references after merging the user's file with its baseline, so a user can
+// override a single base directory that every other path is built from. Value returns the
+// resolved value and RawValue returns the reference as written. Example:
+//
+//  paths {
+//      base    /srv/app
+//  }
+//  log-dir     ${paths/base}/logs
+//
 // Block comments are written using hashtags as the first non-whitespace character
 // of a line. Example:
 //
@@ -104,6 +146,115 @@
 //  }
 //  # root is a pointer to the in-memory hierarchical tree
 //
+// Files may begin with a UTF-8 byte order mark, and may use "\r\n" line endings. Both are
+// stripped as the file is read, and WriteFigtree uses the line endings of the user's file
+// when writing the tree back out. A line that is not valid UTF-8 is reported as a *ParseError.
+//
+// A !baseline file may declare a baseline of its own, so defaults can be layered in a chain,
+// such as organization, team, and service defaults beneath the user's file. The chain is
+// merged from the bottom up, and each item records the file that supplied it.
+//
+// A user's file may remove an item or branch supplied by its baseline with the !unset pragma,
+// whose keyPath is relative to the branch containing the pragma. An item created with
+// NewUnsetItem does the same when a branch is merged with Merge. WriteInternal shows the
+// source of each item that was removed. Example:
+//
+//  !baseline   /etc/app/defaults.fig
+//  !unset      section2/item2-1
+//
+// An array in the user's file replaces the whole of the same array in its baseline. A key
+// written with a + prefix is instead appended to the baseline's array, and the !merge pragma
+// sets the mode, append, prepend, or replace, for every array in the branch containing it,
+// or only for the keys that follow the mode. A key that follows the mode is an array even
+// when it appears once, while other single items and branches are merged as usual. The user's
+// !merge pragmas replace the baseline's. Branch.Merge honors these, as well as ReadConfig,
+// and NewMergeItem creates the equivalent of a !merge pragma. Example:
+//
+//  +ns         ns4.figtree.net
+//  !merge      prepend search
+//  search      local.figtree.net
+//
+// Relative filenames given to the !include, !baseline and !dtd pragmas are resolved
+// against the directory of the file containing the pragma, so a file can refer to its
+// siblings. A leading ~ refers to the user's home directory. The WithWorkingDirPaths
+// option restores resolution against the current working directory.
+//
+// An !include filename may be a glob pattern, such as conf.d/*.fig, and the
+// !include-dir pragma includes every file in a directory. In both cases the files
+// are included in lexical order, and a pattern that matches nothing is not an error.
+//
+// The !include? pragma is an optional include: when its file does not exist it is
+// silently skipped, which allows untracked local overrides to sit next to a committed
+// configuration. Problems within a file that does exist are still reported.
+//
+// The !include-as pragma grafts a file under a new branch key, and a hashtag followed
+// by a keyPath includes only the items of that branch of the other file. A file whose
+// name itself contains a hashtag is still included whole. Examples:
+//
+//  !include-as network  shared/net.fig
+//  !include             shared/all.fig#services/web
+//
+// A single file can carry settings for several environments using !if and !else blocks.
+// Each condition compares a variable passed with the WithVariables option to a value,
+// using == or !=, or tests a variable on its own for a non-empty value. The items of a
+// block that is taken are available as though they were written outside of it, while
+// pragmas within a block that is not taken are ignored. The blocks themselves remain in
+// the tree, so WriteFigtree writes them back out, together with any changes made to the
+// items of a taken block, while WriteJson and WriteYaml write only the items. Example:
+//
+//  !if env == production {
+//      port    443
+//  }
+//  !else {
+//      port    8080
+//  }
+//
+// Named profiles are an alternative to keeping a separate file for each environment.
+// A profile block is ignored unless it is selected with the WithProfiles option, in which
+// case its items are merged over the branch enclosing it, using the rules of Branch.Merge.
+// Several profiles may be selected, and each is merged in turn, so the last one takes
+// precedence. WriteInternal shows which profile supplied each value. WriteFigtree writes the
+// profile blocks and the values they replaced, so the file reads back the same, while WriteJson
+// and WriteYaml write only the merged values. Example:
+//
+//  host    localhost
+//  profile staging {
+//      host    staging.example.com
+//  }
+//
+// Applications may add pragmas of their own, such as !require-version, with RegisterPragma.
+// The handler is called as the pragma is read, with the branch containing it, its value, and
+// a PragmaContext holding its source position. A handler may add items to the branch, record
+// metadata that is available from the root's Metadata method, or return an error to fail the read.
+//
+// Pragmas are matched by their exact name, so a key such as !includes is not a pragma.
+// A key beginning with an exclamation mark that is not a known pragma, such as !foo, is an
+// error, even without a value, which a tolerant read reports before keeping the item as an
+// ordinary key. The WithStrict option also rejects empty keys, keys that could not be written
+// back out, such as those containing a solidus, and keys repeated within a branch, unless they
+// end in [], or are declared as arrays with the !array pragma. Example:
+//
+//  name-servers {
+//      !array  ns
+//      ns      ns1.figtree.net
+//      ns      ns2.figtree.net
+//  }
+//
+// Configurations embedded with go:embed, or held in any other fs.FS, can be read
+// with ReadConfigFS. Every !include, !baseline and !dtd pragma is then resolved
+// through the same fs.FS. Figtree syntax held in an io.Reader or a string can be
+// parsed with ReadFigtreeFrom or ParseString.
+//
+// Every read keeps its own state, so configurations may be read by several goroutines at
+// the same time. A Reader, created with NewReader, holds a set of options for repeated reads
+// and may itself be shared between goroutines.
+//
+// Configurations from untrusted sources can be read with resource limits: WithMaxFileSize,
+// WithMaxLineLength, WithMaxDepth, and WithMaxItems, while WithoutFilePragmas refuses every
+// pragma that would read another file. Exceeding a limit returns a *LimitError, even from a
+// tolerant read. Unless other limits are given, branches may be nested 1000 deep, and lines,
+// such as those holding embedded base64 blobs, may be up to 16 MiB long.
+//
 // An in-memory tree can be saved using any type that implements the SerializeBranch function,
 // which is called by WriteToFile and WriteToBuffer. Example:
 //
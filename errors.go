@@ -15,23 +15,31 @@ func (e Error) Error() string {
 }
 
 const (
-	ErrEOF             = Error("figtree: end of file")
-	ErrNotFound        = Error("figtree: item not found")
-	ErrNotBranch       = Error("figtree: item is not a branch")
-	ErrNotLeaf         = Error("figtree: item is not a leaf")
-	ErrUnknownItemType = Error("figtree: unknown Item type")
+	ErrEOF              = Error("figtree: end of file")
+	ErrNotFound         = Error("figtree: item not found")
+	ErrNotBranch        = Error("figtree: item is not a branch")
+	ErrNotLeaf          = Error("figtree: item is not a leaf")
+	ErrUnknownItemType  = Error("figtree: unknown Item type")
+	ErrSyntax           = Error("figtree: syntax error")
+	ErrIncludeCycle     = Error("figtree: include cycle")
+	ErrIncludeDepth     = Error("figtree: maximum include depth exceeded")
+	ErrRequiredVariable = Error("figtree: required variable not set")
+	ErrReferenceCycle   = Error("figtree: reference cycle")
+	ErrProfileNotFound  = Error("figtree: profile not found")
+	ErrLimitExceeded    = Error("figtree: limit exceeded")
+	ErrInvalidPragma    = Error("figtree: invalid pragma")
 )
 
 // ErrEndOfBranch is a sentinal returned from the recursive call to parse an inner branch.
-// This is a normal return signal for inner branches, but when returned all the
-// way to Read, it signals a premature end to parsing, due to an early closing brace,
+// This is a normal return signal for inner branches, but a closing brace found when no
+// branch is open is reported to the caller as a *ParseError that wraps this sentinal.
 const ErrEndOfBranch = Error("figtree: end of branch")
 
+// ErrUnclosedBranch is matched by a *ParseError reporting that the file ended
+// before the closing brace of a branch was found.
+const ErrUnclosedBranch = Error("figtree: unclosed branch")
+
 // A /dev/null for compiler "declared but not used" messages. This is a development-only
 // function to bypass the compilation error caused by a variable never being used.
 // When in production, there should be no calls to this function.
 func todo(x ...interface{}) {}
-
-func init() {
-	todo(gDtdTree)
-}
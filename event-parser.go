@@ -0,0 +1,333 @@
+//=============================================================================
+// File:     event-parser.go
+// Contents: EventKind enum declaration
+//           Event type declaration
+//           EventParser type declaration
+//           NewEventParser
+//           Next, Depth, LineEnding methods
+//=============================================================================
+
+package figtree
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The EventKind type identifies the syntactic construct reported by an Event.
+type EventKind int
+
+const (
+	EventBeginBranch EventKind = iota // a key followed by an opening brace
+	EventKeyValue                     // a key and its value, which may be a heredoc
+	EventComment                      // a block comment, or a blank line
+	EventEndBranch                    // the closing brace of the most recently opened branch
+)
+
+func (kind EventKind) String() string {
+	return [...]string{"BeginBranch", "KeyValue", "Comment", "EndBranch"}[kind]
+}
+
+// The Event type describes one construct of figtree syntax, in the order it appears in the file.
+// Events describe the syntax only: values are neither expanded nor checked for references,
+// and pragmas are reported like any other key/value pair.
+type Event struct {
+	Kind               EventKind
+	Key                string // the key of a BeginBranch or KeyValue event, and of the branch closed by an EndBranch event
+	Value              string // the unquoted value of a KeyValue event, or the condition of an !if block, or the name of a profile block
+	Text               string // the trimmed text of a Comment event, which is empty for a blank line
+	TerminalWhitespace string // the whitespace between the value and its terminal comment
+	TerminalComment    string // the terminal comment, without its hashtag
+	Heredoc            bool   // true when the value of a KeyValue event was read from a heredoc
+	SrcFile            string // the file being read
+	SrcLine            int    // the 1-based line number of the event, which is the first line of a heredoc
+	LineText           string // the text of that line, as it appears in the file
+	Depth              int    // the number of enclosing branches, not counting a branch that the event begins or ends
+}
+
+// The ItemKey method returns the key that the event's item has in a tree, which for the
+// opening of an !if block or a profile block is the pragma followed by its condition or name.
+func (event *Event) ItemKey() string {
+	if event.Kind == EventBeginBranch && event.Value != "" {
+		return event.Key + " " + event.Value
+	}
+	return event.Key
+}
+
+// The EventParser type reads figtree syntax one line at a time, reporting each construct
+// as an Event, without building a tree. Only the current line, or the current heredoc,
+// and the opening events of the branches that are still open are held in memory,
+// so files of any size can be scanned for a few keyPaths.
+//
+// Pragmas are not acted upon; no other file is ever read by an EventParser.
+type EventParser struct {
+	ctx          *readContext
+	scanner      *bufio.Scanner
+	lineEndings  *lineEndingCounter // nil when the scanner was created by the caller
+	srcFile      string
+	srcLine      *int    // the number of lines read so far
+	lineCount    int     // the line counter used when the caller does not supply one
+	openBranches []Event // the BeginBranch event of each branch that is still open, outermost first
+	err          error   // a scanner or limit error, which is returned by every later call to Next
+}
+
+// The NewEventParser function creates a parser for the figtree syntax held in the reader,
+// whose events are attributed to srcFile. The WithMaxFileSize, WithMaxLineLength, WithMaxDepth,
+// and WithQuotedValues options are honored; the other read options have no effect.
+func NewEventParser(r io.Reader, srcFile string, options ...ReadOption) *EventParser {
+	ctx := newReadContext(options)
+	return ctx.newEventParser(r, srcFile)
+}
+
+// Create a parser that enforces the context's limits, with a scanner of its own.
+func (ctx *readContext) newEventParser(r io.Reader, srcFile string) *EventParser {
+	scanner, lineEndings := ctx.newScanner(r, srcFile)
+	parser := &EventParser{
+		ctx:         ctx,
+		scanner:     scanner,
+		lineEndings: lineEndings,
+		srcFile:     srcFile,
+	}
+	parser.srcLine = &parser.lineCount
+	return parser
+}
+
+// The Next method reads as many lines as are needed to return the next event.
+//
+// Returns io.EOF once the reader is exhausted and every branch has been closed.
+// Returns a *ParseError for a line with a syntax error, after which the line is skipped,
+// and the following call carries on with the next line. A branch that is still open when
+// the reader is exhausted is reported as a *ParseError pointing to the line that opened it,
+// innermost first, one for each call.
+// Returns a *LimitError, or the scanner's error together with the line that could not be read,
+// when reading cannot continue; every later call returns the same error.
+func (parser *EventParser) Next() (Event, error) {
+	if parser.err != nil {
+		return Event{}, parser.err
+	}
+	ctx := parser.ctx
+	srcFile := parser.srcFile
+	srcLine := parser.srcLine
+
+	for parser.scanner.Scan() { // advance the scanner to the end of line
+		var leftSide, rightSide string
+		var key, val, terminalWhitespace, terminalComment string
+
+		// copy the runes into a string and remove leading and trailing whitespace
+		lineText := parser.scanner.Text()
+		if *srcLine == 0 {
+			lineText = strings.TrimPrefix(lineText, byteOrderMark)
+		}
+		line := strings.Trim(lineText, " \t")
+		*srcLine++
+		event := Event{SrcFile: srcFile, SrcLine: *srcLine, LineText: lineText, Depth: len(parser.openBranches)}
+
+		if offset := invalidUTF8Offset(lineText); offset != -1 {
+			return Event{}, &ParseError{SrcFile: srcFile, SrcLine: *srcLine, Column: offset + 1, LineText: lineText, Reason: ReasonInvalidUTF8}
+		}
+
+		// blank lines and comment lines are block comments
+		if len(line) == 0 || line[0] == '#' {
+			event.Kind = EventComment
+			event.Text = line
+			return event, nil
+		}
+
+		// split into two halves based on first whitespace or opening-brace
+		whitespace := strings.IndexAny(line, " \t{")
+		if whitespace == -1 {
+			leftSide = line
+			rightSide = ""
+			key = leftSide
+		} else {
+			leftSide = line[:whitespace]
+			rightSide = line[whitespace:]
+			key = leftSide
+		}
+
+		// with the WithQuotedValues option, a value that begins with a quotation mark is delimited
+		// by a closing quotation mark and may contain escape sequences, so that any string can be represented
+		quoted := false
+		trimmedRight := strings.TrimLeft(rightSide, " \t")
+		if ctx.quotedValues && len(trimmedRight) > 0 && trimmedRight[0] == '"' && !strings.HasPrefix(leftSide, "}") {
+			var remainder, offending string
+			var reason ParseReason
+			var ok bool
+			val, remainder, reason, offending, ok = unquoteValue(trimmedRight)
+			if ok {
+				remaining := strings.TrimLeft(remainder, " \t")
+				if len(remaining) > 0 && remaining[0] == '#' {
+					terminalWhitespace = remainder[:len(remainder)-len(remaining)]
+					terminalComment = strings.Trim(remaining[1:], " \t")
+				} else if len(remaining) > 0 {
+					reason = ReasonTextAfterQuote
+					offending = remaining
+					ok = false
+				}
+			}
+			if !ok {
+				return Event{}, newParseError(srcFile, *srcLine, lineText, offending, reason)
+			}
+			quoted = true
+		} else {
+			// split right side into value and possible comment
+			// hashtags that are not preceded by whitespace are treated as part
+			// of the value, to allow for things like URLs with bookmarks
+			hash := strings.Index(rightSide, "\t#")
+			if hash == -1 {
+				hash = strings.Index(rightSide, " #")
+			}
+			if hash == -1 {
+				val = strings.Trim(rightSide, " \t")
+				terminalWhitespace = ""
+				terminalComment = ""
+			} else {
+				val = strings.TrimLeft(rightSide[:hash+1], " \t")         // keep the trailing whitespace for next step
+				terminalComment = strings.Trim(rightSide[hash+2:], " \t") // drop the whitespace and hash
+
+				// extract the whitespace between value and hash
+				pos := -1
+				for i := len(val) - 1; i >= 0; i-- {
+					if val[i] != ' ' && val[i] != '\t' {
+						pos = i
+						break
+					}
+				}
+				if pos != -1 {
+					terminalWhitespace = val[pos+1:]
+					val = val[:pos+1]
+				}
+			}
+		}
+		event.Key = key
+		event.TerminalWhitespace = terminalWhitespace
+		event.TerminalComment = terminalComment
+
+		if opening, ok := beginsBranch(key, val, quoted); ok {
+			// begin branch
+			if ctx.maxDepth > 0 && len(parser.openBranches)+1 > ctx.maxDepth {
+				parser.err = &LimitError{Limit: LimitDepth, Max: int64(ctx.maxDepth), SrcFile: srcFile, SrcLine: *srcLine}
+				return Event{}, parser.err
+			}
+			event.Kind = EventBeginBranch
+			event.Value = opening
+			parser.openBranches = append(parser.openBranches, event)
+			return event, nil
+		} else if len(leftSide) > 0 && leftSide[0] == '}' {
+			// end branch, which is only legitimate when a branch is open
+			if len(parser.openBranches) == 0 {
+				return Event{}, newParseError(srcFile, *srcLine, lineText, "}", ReasonUnexpectedClosingBrace)
+			}
+			opened := parser.openBranches[len(parser.openBranches)-1]
+			parser.openBranches = parser.openBranches[:len(parser.openBranches)-1]
+			event.Kind = EventEndBranch
+			event.Key = opened.ItemKey()
+			event.Depth = len(parser.openBranches)
+			event.TerminalWhitespace = ""
+			event.TerminalComment = ""
+			return event, nil
+		}
+
+		// typical key/value, or the first line of a multi-line heredoc value
+		event.Kind = EventKeyValue
+		event.Value = val
+		if delimiter, indented, ok := parseHeredocOperator(val); ok && !quoted {
+			var terminated bool
+			var err error
+			event.Heredoc = true
+			event.Value, terminated, err = readHeredoc(parser.scanner, srcLine, delimiter, indented)
+			if err != nil {
+				return Event{}, parser.scannerFailed(err)
+			}
+			if !terminated {
+				parseErr := newParseError(srcFile, event.SrcLine, lineText, "<<", ReasonUnterminatedHeredoc)
+				parseErr.Key = key
+				return Event{}, parseErr
+			}
+			if offset := invalidUTF8Offset(event.Value); offset != -1 {
+				return Event{}, heredocUTF8Error(event, offset)
+			}
+		}
+		return event, nil
+	}
+
+	if err := parser.scanner.Err(); err != nil {
+		return Event{}, parser.scannerFailed(err)
+	}
+
+	// every branch still open is reported, innermost first
+	if count := len(parser.openBranches); count > 0 {
+		opened := parser.openBranches[count-1]
+		parser.openBranches = parser.openBranches[:count-1]
+		parseErr := newParseError(srcFile, opened.SrcLine, opened.LineText, "{", ReasonUnclosedBrace)
+		parseErr.Key = opened.ItemKey()
+		return Event{}, parseErr
+	}
+	return Event{}, io.EOF
+}
+
+// The scanner stops early when it can't read the file, or a limit is exceeded, so report the
+// line that could not be read, such as a line too long for the scanner's buffer.
+//
+// Returns the error, which every later call to Next returns as well.
+func (parser *EventParser) scannerFailed(err error) error {
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) {
+		err = fmt.Errorf("%s:%d: %w", parser.srcFile, *parser.srcLine+1, err)
+	}
+	parser.err = err
+	return err
+}
+
+// The Depth method returns the number of branches that are currently open.
+func (parser *EventParser) Depth() int {
+	return len(parser.openBranches)
+}
+
+// The LineEnding method returns the line ending used by most of the lines read so far,
+// which is "\n" unless most were "\r\n".
+func (parser *EventParser) LineEnding() string {
+	if parser.lineEndings == nil {
+		return "\n"
+	}
+	return parser.lineEndings.lineEnding()
+}
+
+// Determine whether a key and unquoted value open a branch. The value of a plain branch is
+// only the opening brace, while the value of an !if block, an !else block, or a profile block
+// is its condition or name followed by the opening brace.
+//
+// Returns the text preceding the opening brace, without its whitespace.
+func beginsBranch(key string, value string, quoted bool) (string, bool) {
+	if quoted {
+		return "", false
+	}
+	if value == "{" {
+		return "", true
+	}
+	if key == "!if" || key == "!else" {
+		if strings.HasSuffix(value, "{") {
+			return strings.Trim(strings.TrimSuffix(value, "{"), " \t"), true
+		}
+		return "", false
+	}
+	return parseProfileOperator(key, value)
+}
+
+// Create the error for a heredoc value that is not valid UTF-8,
+// pointing to the heredoc line containing the offending byte.
+func heredocUTF8Error(event Event, offset int) *ParseError {
+	val := event.Value
+	lineStart := strings.LastIndex(val[:offset], "\n") + 1
+	lineEnd := strings.Index(val[offset:], "\n")
+	if lineEnd == -1 {
+		lineEnd = len(val)
+	} else {
+		lineEnd += offset
+	}
+	heredocLine := event.SrcLine + 1 + strings.Count(val[:offset], "\n")
+	return &ParseError{SrcFile: event.SrcFile, SrcLine: heredocLine, Column: offset - lineStart + 1, LineText: val[lineStart:lineEnd], Reason: ReasonInvalidUTF8}
+}
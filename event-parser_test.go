@@ -0,0 +1,176 @@
+//=============================================================================
+// File:     event-parser_test.go
+// Tests:    EventParser reports each construct with its source position
+//           Conditional and profile blocks are reported as branches
+//           A syntax error skips the line, and unclosed branches are reported at the end
+//           A large generated file is scanned one line at a time
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/readwritepro/figtree"
+)
+
+// Read every event from the parser, collecting a one-line summary of each, and any errors.
+func readEvents(parser *figtree.EventParser) ([]string, []error) {
+	summaries := make([]string, 0)
+	errs := make([]error, 0)
+	for {
+		event, err := parser.Next()
+		if err == io.EOF {
+			return summaries, errs
+		}
+		if err != nil {
+			errs = append(errs, err)
+			var parseErr *figtree.ParseError
+			if !errors.As(err, &parseErr) {
+				return summaries, errs
+			}
+			continue
+		}
+		summaries = append(summaries, fmt.Sprintf("%d:%d %v %s=%s%s", event.SrcLine, event.Depth, event.Kind, event.Key, event.Value, event.Text))
+	}
+}
+
+func TestEventParser(t *testing.T) {
+	input := `# hosts
+hostname figtree    # the short name
+ip-settings {
+	ip4 {
+		inet 179.100.102.215
+	}
+
+	script <<END
+echo "hello"
+END
+}
+`
+	actual, errs := readEvents(figtree.NewEventParser(strings.NewReader(input), "string"))
+	if len(errs) != 0 {
+		t.Fatalf("expected no errors, got '%v'", errs)
+	}
+	expected := []string{
+		"1:0 Comment =# hosts",
+		"2:0 KeyValue hostname=figtree",
+		"3:0 BeginBranch ip-settings=",
+		"4:1 BeginBranch ip4=",
+		"5:2 KeyValue inet=179.100.102.215",
+		"6:1 EndBranch ip4=",
+		"7:1 Comment =",
+		"8:1 KeyValue script=echo \"hello\"",
+		"11:0 EndBranch ip-settings=",
+	}
+	if strings.Join(expected, "\n") != strings.Join(actual, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestEventParserBlocks(t *testing.T) {
+	input := "!if env == production {\n\tport 443\n}\n!else {\n\tport 8080\n}\nprofile staging {\n\thost staging\n}\n"
+	actual, errs := readEvents(figtree.NewEventParser(strings.NewReader(input), "string"))
+	if len(errs) != 0 {
+		t.Fatalf("expected no errors, got '%v'", errs)
+	}
+	expected := []string{
+		"1:0 BeginBranch !if=env == production",
+		"2:1 KeyValue port=443",
+		"3:0 EndBranch !if env == production=",
+		"4:0 BeginBranch !else=",
+		"5:1 KeyValue port=8080",
+		"6:0 EndBranch !else=",
+		"7:0 BeginBranch profile=staging",
+		"8:1 KeyValue host=staging",
+		"9:0 EndBranch profile staging=",
+	}
+	if strings.Join(expected, "\n") != strings.Join(actual, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestEventParserErrors(t *testing.T) {
+	input := "key1 \"unterminated\n}\nouter {\n\tinner {\n\t\tkey2 value2\n"
+	actual, errs := readEvents(figtree.NewEventParser(strings.NewReader(input), "string", figtree.WithQuotedValues()))
+	expected := []string{
+		"3:0 BeginBranch outer=",
+		"4:1 BeginBranch inner=",
+		"5:2 KeyValue key2=value2",
+	}
+	if strings.Join(expected, "\n") != strings.Join(actual, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+
+	// the unclosed branches are reported innermost first
+	expectedErrs := []figtree.ParseReason{
+		figtree.ReasonUnterminatedQuote,
+		figtree.ReasonUnexpectedClosingBrace,
+		figtree.ReasonUnclosedBrace,
+		figtree.ReasonUnclosedBrace,
+	}
+	expectedLines := []int{1, 2, 4, 3}
+	if len(expectedErrs) != len(errs) {
+		t.Fatalf("expected %d errors, got '%v'", len(expectedErrs), errs)
+	}
+	for i, err := range errs {
+		var parseErr *figtree.ParseError
+		if !errors.As(err, &parseErr) || parseErr.Reason != expectedErrs[i] || parseErr.SrcLine != expectedLines[i] {
+			t.Errorf("expected '%v' on line %d, got '%v'", expectedErrs[i], expectedLines[i], err)
+		}
+	}
+
+	// a limit halts the parser, and is returned by every later call
+	parser := figtree.NewEventParser(strings.NewReader("a {\n\tb {\n\t}\n}\n"), "string", figtree.WithMaxDepth(1))
+	_, errs = readEvents(parser)
+	_, err := parser.Next()
+	if len(errs) != 1 || !errors.Is(errs[0], figtree.ErrLimitExceeded) || !errors.Is(err, figtree.ErrLimitExceeded) {
+		t.Errorf("expected '%v', got '%v' and '%v'", figtree.ErrLimitExceeded, errs, err)
+	}
+}
+
+// The inventoryReader type generates a large inventory, one host at a time, without holding it in memory.
+type inventoryReader struct {
+	hosts   int
+	pending []byte
+}
+
+func (ir *inventoryReader) Read(p []byte) (int, error) {
+	if len(ir.pending) == 0 {
+		if ir.hosts == 0 {
+			return 0, io.EOF
+		}
+		ir.pending = []byte(fmt.Sprintf("host {\n\tname host%d\n\taddress 10.0.%d.%d\n}\n", ir.hosts, ir.hosts/256%256, ir.hosts%256))
+		ir.hosts--
+	}
+	n := copy(p, ir.pending)
+	ir.pending = ir.pending[n:]
+	return n, nil
+}
+
+func TestEventParserLargeFile(t *testing.T) {
+	const hosts = 100000
+	parser := figtree.NewEventParser(&inventoryReader{hosts: hosts}, "inventory")
+	count := 0
+	last := ""
+	for {
+		event, err := parser.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected 'nil', got '%v'", err)
+		}
+		if event.Kind == figtree.EventKeyValue && event.Key == "name" && event.Depth == 1 {
+			count++
+			last = event.Value
+		}
+	}
+	if count != hosts || last != "host1" {
+		t.Errorf("expected %d hosts ending with 'host1', got %d ending with '%s'", hosts, count, last)
+	}
+}
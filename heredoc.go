@@ -0,0 +1,90 @@
+//=============================================================================
+// File:     heredoc.go
+// Contents: Multi-line heredoc values
+//           parseHeredocOperator, readHeredoc, heredocDelimiter
+//=============================================================================
+
+package figtree
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// Determine whether a value is a heredoc operator, such as <<END or <<-END.
+// The delimiter must begin with a letter or underscore, followed by letters, digits, or underscores.
+//
+// Returns the delimiter, and whether the block's indentation should be stripped.
+func parseHeredocOperator(value string) (delimiter string, indented bool, ok bool) {
+	if !strings.HasPrefix(value, "<<") {
+		return "", false, false
+	}
+	delimiter = value[2:]
+	if strings.HasPrefix(delimiter, "-") {
+		delimiter = delimiter[1:]
+		indented = true
+	}
+	if !isIdentifier(delimiter) {
+		return "", false, false
+	}
+	return delimiter, indented, true
+}
+
+// Read the lines of a heredoc block, up to the line containing only the delimiter.
+// The lines are kept verbatim. When indented is true, the whitespace preceding the
+// closing delimiter is removed from the beginning of every line of the block.
+//
+// Returns the lines joined with line feeds, without a trailing line feed.
+// Returns false when the scanner is exhausted before the delimiter is found, together with
+// the scanner's error when it stopped because a line could not be read.
+func readHeredoc(scanner *bufio.Scanner, srcLine *int, delimiter string, indented bool) (string, bool, error) {
+	lines := make([]string, 0)
+	for scanner.Scan() {
+		*srcLine++
+		lineText := scanner.Text()
+		if strings.Trim(lineText, " \t") == delimiter {
+			if indented {
+				indent := lineText[:len(lineText)-len(strings.TrimLeft(lineText, " \t"))]
+				for i := range lines {
+					lines[i] = strings.TrimPrefix(lines[i], indent)
+				}
+			}
+			return strings.Join(lines, "\n"), true, nil
+		}
+		lines = append(lines, lineText)
+	}
+	return "", false, scanner.Err()
+}
+
+// Choose a delimiter for writing a multi-line value as an indented heredoc.
+//
+// Returns an empty string when the value is a single line, or contains characters,
+// such as carriage returns, that would not survive being read back from a heredoc.
+func heredocDelimiter(value string) string {
+	if !strings.Contains(value, "\n") {
+		return ""
+	}
+	for _, r := range value {
+		if (r < 0x20 && r != '\n' && r != '\t') || r == 0x7F {
+			return ""
+		}
+	}
+
+	// the delimiter must not appear on a line of its own within the value
+	lines := strings.Split(value, "\n")
+	delimiter := "END"
+	for n := 1; ; n++ {
+		collision := false
+		for _, line := range lines {
+			if strings.Trim(line, " \t") == delimiter {
+				collision = true
+				break
+			}
+		}
+		if !collision {
+			return delimiter
+		}
+		delimiter = fmt.Sprintf("END%d", n)
+	}
+}
@@ -0,0 +1,160 @@
+//=============================================================================
+// File:     heredoc_test.go
+// Tests:    Read verbatim and indented heredocs
+//           Unterminated heredoc
+//           Scanner errors within a heredoc
+//           WriteFigtree, WriteJson, and WriteYaml of multi-line values
+//           WriteYaml of a heredoc containing apostrophes
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/readwritepro/figtree"
+)
+
+func TestReadHeredoc(t *testing.T) {
+	input := `key1 value1
+certs {
+	pem <<END
+-----BEGIN CERTIFICATE-----
+  MIIBszCCAVmgAwIBAgIU # not a comment
+-----END CERTIFICATE-----
+END
+	sql <<-SQL		# indentation is stripped
+		SELECT *
+		  FROM items
+
+		 WHERE id = 1
+		SQL
+}
+key2 value2
+`
+	root, err := figtree.ParseString(input)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	tests := map[string]string{
+		"certs/pem": "-----BEGIN CERTIFICATE-----\n  MIIBszCCAVmgAwIBAgIU # not a comment\n-----END CERTIFICATE-----",
+		"certs/sql": "SELECT *\n  FROM items\n\n WHERE id = 1",
+		"key2":      "value2",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%q', got '%q' (%v)", keyPath, expected, actual, err)
+		}
+	}
+
+	// the heredoc's srcLine is the line containing its key
+	wi := figtree.WriteInternal{}
+	buf, _ := root.WriteToBuffer(wi)
+	if !strings.Contains(buf, "[string:8]") || !strings.Contains(buf, "[string:15]") {
+		t.Errorf("expected srcLines 8 and 15, got\n%s", buf)
+	}
+}
+
+func TestUnterminatedHeredoc(t *testing.T) {
+	_, err := figtree.ParseString("key1 value1\nkey2 <<END\nline\n")
+	if !errors.Is(err, figtree.ErrSyntax) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrSyntax, err)
+	}
+
+	expected := "string:2:6: unterminated heredoc for 'key2'"
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+}
+
+func TestWriteHeredoc(t *testing.T) {
+	value := "first line\n\tindented line\n\nEND\n  last line"
+
+	root := figtree.NewBranch()
+	section := figtree.NewItem("section", "")
+	section.SetBranch(figtree.NewBranch())
+	inner, _ := section.Branch()
+	inner.AppendItem(figtree.NewItem("script", value))
+	root.AppendItem(section)
+
+	wf := figtree.WriteFigtree{}
+	buf, err := root.WriteToBuffer(wf)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	if !strings.Contains(buf, "script <<-END1\n") {
+		t.Errorf("expected a heredoc with a delimiter not found in the value, got\n%s", buf)
+	}
+
+	reread, err := figtree.ParseString(buf)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'\n%s", err, buf)
+	}
+	actual, _ := reread.GetValue("section/script")
+	if value != actual {
+		t.Errorf("expected '%q', got '%q'", value, actual)
+	}
+
+	wj := figtree.WriteJson{}
+	buf, _ = root.WriteToBuffer(wj)
+	expected := `"script": "first line\n\tindented line\n\nEND\n  last line"`
+	if !strings.Contains(buf, expected) {
+		t.Errorf("expected '%s', got\n%s", expected, buf)
+	}
+
+	wy := figtree.WriteYaml{}
+	buf, _ = root.WriteToBuffer(wy)
+	expected = `script: "first line\n\tindented line\n\nEND\n  last line"`
+	if !strings.Contains(buf, expected) {
+		t.Errorf("expected '%s', got\n%s", expected, buf)
+	}
+}
+
+func TestWriteYamlHeredoc(t *testing.T) {
+	input := "queries {\n\tsql <<-SQL\n\t\tSELECT 'x'\n\t\t  FROM t\n\t\tSQL\n}\nname 'quoted'\n"
+	root, err := figtree.ParseString(input)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	// an apostrophe is not escaped within a double-quoted scalar
+	wy := figtree.WriteYaml{}
+	buf, _ := root.WriteToBuffer(wy)
+	for _, expected := range []string{`sql: "SELECT 'x'\n  FROM t"`, `name: "'quoted'"`} {
+		if !strings.Contains(buf, expected) {
+			t.Errorf("expected '%s', got\n%s", expected, buf)
+		}
+	}
+	if strings.Contains(buf, `\'`) {
+		t.Errorf("expected no escaped apostrophes, got\n%s", buf)
+	}
+}
+
+func TestHeredocScannerErrors(t *testing.T) {
+	// a line too long for the limit is reported as such, not as an unterminated heredoc
+	input := "cert <<END\n" + strings.Repeat("x", 200) + "\nEND\n"
+	_, err := figtree.ParseString(input, figtree.WithMaxLineLength(100))
+	var limitErr *figtree.LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != figtree.LimitLineLength || limitErr.SrcLine != 2 {
+		t.Errorf("expected a line length limit at line 2, got '%v'", err)
+	}
+	_, diagnostics := figtree.ReadFigtreeFromTolerant(strings.NewReader(input), "tolerant", figtree.WithMaxLineLength(100))
+	if len(diagnostics) != 1 || !errors.As(diagnostics[0].Err, &limitErr) {
+		t.Errorf("expected only the line length limit, got %v", diagnostics)
+	}
+
+	r := io.MultiReader(strings.NewReader("cert <<END\nline 1\n"), &failingReader{})
+	_, err = figtree.ReadFigtreeFrom(r, "failing")
+	if !errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, figtree.ErrSyntax) {
+		t.Errorf("expected '%v', got '%v'", io.ErrUnexpectedEOF, err)
+	}
+	expected := "failing:3: unexpected EOF"
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+}
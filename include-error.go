@@ -0,0 +1,47 @@
+//=============================================================================
+// File:     include-error.go
+// Contents: IncludeLink type declaration
+//           IncludeError type declaration
+//=============================================================================
+
+package figtree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The IncludeLink type records one pragma in a chain of !include, !baseline, or !dtd
+// pragmas: the file and line containing the pragma, and the file that it refers to.
+type IncludeLink struct {
+	SrcFile  string // the file containing the pragma
+	SrcLine  int    // the 1-based line number of the pragma
+	Pragma   string // the pragma, such as "!include"
+	Filename string // the resolved name of the file referred to by the pragma
+}
+
+func (link IncludeLink) String() string {
+	return fmt.Sprintf("%s:%d %s %s", link.SrcFile, link.SrcLine, link.Pragma, link.Filename)
+}
+
+// The IncludeError type is returned when a chain of pragmas refers back to a file that
+// is still being read, or is nested more deeply than the maximum include depth.
+// The Err field is either ErrIncludeCycle or ErrIncludeDepth, and is matched by errors.Is.
+type IncludeError struct {
+	Chain []IncludeLink // every pragma in the chain, outermost first
+	Err   error
+}
+
+// Formats the error as the sentinel's message followed by each link of the chain.
+func (e *IncludeError) Error() string {
+	links := make([]string, 0, len(e.Chain))
+	for _, link := range e.Chain {
+		links = append(links, link.String())
+	}
+	return fmt.Sprintf("%v: %s", e.Err, strings.Join(links, ", "))
+}
+
+// Returns ErrIncludeCycle or ErrIncludeDepth.
+func (e *IncludeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,72 @@
+//=============================================================================
+// File:     include-error_test.go
+// Tests:    Include cycle
+//           Maximum include depth
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/readwritepro/figtree"
+)
+
+func TestIncludeCycle(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.fig": {Data: []byte("key1 value1\n!include b.fig\n")},
+		"b.fig": {Data: []byte("section {\n\t!include a.fig\n}\n")},
+	}
+	_, err := figtree.ReadConfigFS(fsys, "a.fig")
+	if !errors.Is(err, figtree.ErrIncludeCycle) {
+		t.Fatalf("expected '%v', got '%v'", figtree.ErrIncludeCycle, err)
+	}
+
+	var includeErr *figtree.IncludeError
+	if !errors.As(err, &includeErr) {
+		t.Fatalf("expected a *figtree.IncludeError, got '%T'", err)
+	}
+	expected := "figtree: include cycle: a.fig:2 !include b.fig, b.fig:2 !include a.fig"
+	if expected != err.Error() {
+		t.Errorf("expected '%s', got '%s'", expected, err.Error())
+	}
+
+	// a baseline that refers back to the user's file is also a cycle
+	fsys = fstest.MapFS{
+		"user.fig":     {Data: []byte("!baseline baseline.fig\n")},
+		"baseline.fig": {Data: []byte("!include user.fig\n")},
+	}
+	_, err = figtree.ReadConfigFS(fsys, "user.fig")
+	if !errors.Is(err, figtree.ErrIncludeCycle) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrIncludeCycle, err)
+	}
+}
+
+func TestMaxIncludeDepth(t *testing.T) {
+	fsys := fstest.MapFS{
+		"level0.fig": {Data: []byte("!include level1.fig\n")},
+		"level1.fig": {Data: []byte("!include level2.fig\n")},
+		"level2.fig": {Data: []byte("key value\n")},
+	}
+	_, err := figtree.ReadConfigFS(fsys, "level0.fig", figtree.WithMaxIncludeDepth(2))
+	if err != nil {
+		t.Errorf("expected 'nil', got '%v'", err)
+	}
+
+	_, err = figtree.ReadConfigFS(fsys, "level0.fig", figtree.WithMaxIncludeDepth(1))
+	if !errors.Is(err, figtree.ErrIncludeDepth) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrIncludeDepth, err)
+	}
+
+	// the same file may be included more than once, as long as it is not its own ancestor
+	fsys = fstest.MapFS{
+		"main.fig":   {Data: []byte("a {\n\t!include common.fig\n}\nb {\n\t!include common.fig\n}\n")},
+		"common.fig": {Data: []byte("key value\n")},
+	}
+	_, err = figtree.ReadConfigFS(fsys, "main.fig")
+	if err != nil {
+		t.Errorf("expected 'nil', got '%v'", err)
+	}
+}
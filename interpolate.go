@@ -0,0 +1,136 @@
+//=============================================================================
+// File:     interpolate.go
+// Contents: Environment variable and intra-tree reference interpolation within leaf values
+//           expandVariables
+//=============================================================================
+
+package figtree
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The variableError type describes a malformed or unsatisfied variable reference.
+// It is converted into a *ParseError by the parser, which knows the position of the line.
+// It is not returned to callers directly, so it only needs to satisfy the error interface.
+type variableError struct {
+	reason    ParseReason
+	reference string // the complete reference, such as ${DB_PASSWORD:?required}
+	name      string // the variable name
+	detail    string // the message supplied by a ${NAME:?message} reference
+}
+
+func (e *variableError) Error() string {
+	return fmt.Sprintf("%v %s", e.reason, e.reference)
+}
+
+// Expand every variable reference within a raw value. The recognized forms are:
+//
+//	${NAME}            the value of NAME, or an empty string when it is not set
+//	${NAME:-default}   the value of NAME, or default when it is not set or is empty
+//	${NAME:?message}   the value of NAME, or an error with the message when it is not set or is empty
+//	${key/path}        the value of another item in the tree, when the reference contains a slash
+//	$$                 a literal dollar sign
+//
+// A dollar sign that is not followed by an opening brace or another dollar sign is kept as is.
+// When lookupRef is nil, references to other items are kept as is, to be resolved later.
+//
+// Returns a *variableError when a reference is malformed or a required variable is not set,
+// or the error returned by lookupRef.
+func expandVariables(raw string, lookupEnv func(string) (string, bool), lookupRef func(string) (string, error)) (string, error) {
+	if !strings.Contains(raw, "$") {
+		return raw, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(raw); {
+		if raw[i] != '$' || i+1 >= len(raw) {
+			sb.WriteByte(raw[i])
+			i++
+			continue
+		}
+		if raw[i+1] == '$' {
+			sb.WriteByte('$')
+			i += 2
+			continue
+		}
+		if raw[i+1] != '{' {
+			sb.WriteByte('$')
+			i++
+			continue
+		}
+
+		closing := strings.IndexByte(raw[i:], '}')
+		if closing == -1 {
+			return "", &variableError{reason: ReasonMalformedVariable, reference: raw[i:]}
+		}
+		reference := raw[i : i+closing+1]
+		expression := reference[2 : len(reference)-1]
+		i += len(reference)
+
+		// a reference to another item in the tree
+		if strings.Contains(expression, "/") {
+			if lookupRef == nil {
+				sb.WriteString(reference)
+				continue
+			}
+			value, err := lookupRef(expression)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(value)
+			continue
+		}
+
+		name, operator, operand := expression, "", ""
+		if op := strings.Index(expression, ":"); op != -1 && op+1 < len(expression) {
+			name, operator, operand = expression[:op], expression[op:op+2], expression[op+2:]
+		}
+		if !isIdentifier(name) || (operator != "" && operator != ":-" && operator != ":?") {
+			return "", &variableError{reason: ReasonMalformedVariable, reference: reference, name: name}
+		}
+
+		value, isSet := lookupEnv(name)
+		if !isSet || value == "" {
+			switch operator {
+			case ":-":
+				value = operand
+			case ":?":
+				if operand == "" {
+					operand = "required"
+				}
+				return "", &variableError{reason: ReasonRequiredVariable, reference: reference, name: name, detail: operand}
+			}
+		}
+		sb.WriteString(value)
+	}
+	return sb.String(), nil
+}
+
+// Determines whether a raw value contains anything that expandVariables would change.
+func hasVariables(raw string) bool {
+	return strings.Contains(raw, "${") || strings.Contains(raw, "$$")
+}
+
+// Determines whether the name is a valid environment variable name or heredoc delimiter:
+// a letter or underscore, followed by letters, digits, or underscores.
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		isLetter := c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && !(isDigit && i > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// The default lookup function, which consults the process environment.
+func lookupEnv(name string) (string, bool) {
+	return os.LookupEnv(name)
+}
@@ -0,0 +1,167 @@
+//=============================================================================
+// File:     interpolate_test.go
+// Tests:    Expand environment variables in leaf values
+//           Required and malformed variable references
+//           Values are kept as written without WithEnvExpansion
+//           WriteFigtree writes the unexpanded template
+//           WriteFigtree escapes the dollar signs of values set in code, when asked to
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/readwritepro/figtree"
+)
+
+func TestExpandVariables(t *testing.T) {
+	os.Setenv("FIGTREE_TEST_HOME", "/home/fig")
+	os.Setenv("FIGTREE_TEST_EMPTY", "")
+	os.Unsetenv("FIGTREE_TEST_PORT")
+	defer os.Unsetenv("FIGTREE_TEST_HOME")
+	defer os.Unsetenv("FIGTREE_TEST_EMPTY")
+
+	input := `home ${FIGTREE_TEST_HOME}/config
+port ${FIGTREE_TEST_PORT:-8080}
+empty [${FIGTREE_TEST_EMPTY}]
+fallback ${FIGTREE_TEST_EMPTY:-default}
+unset [${FIGTREE_TEST_PORT}]
+price $$5 and $HOME
+script <<END
+echo ${FIGTREE_TEST_HOME}
+END
+`
+	root, err := figtree.ParseString(input, figtree.WithEnvExpansion())
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	tests := map[string]string{
+		"home":     "/home/fig/config",
+		"port":     "8080",
+		"empty":    "[]",
+		"fallback": "default",
+		"unset":    "[]",
+		"price":    "$5 and $HOME",
+		"script":   "echo ${FIGTREE_TEST_HOME}",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", keyPath, expected, actual, err)
+		}
+	}
+
+	item, _ := root.GetItem("home")
+	actual, _ := item.RawValue()
+	expected := "${FIGTREE_TEST_HOME}/config"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+
+	// the template is written back out, rather than the expanded value
+	wf := figtree.WriteFigtree{}
+	buf, _ := root.WriteToBuffer(wf)
+	if !strings.Contains(buf, "home ${FIGTREE_TEST_HOME}/config\n") || strings.Contains(buf, "/home/fig") {
+		t.Errorf("expected the unexpanded template, got\n%s", buf)
+	}
+
+	// changing the value discards the template
+	item.SetValue("/tmp")
+	actual, _ = item.RawValue()
+	if actual != "/tmp" {
+		t.Errorf("expected '/tmp', got '%s'", actual)
+	}
+}
+
+func TestRequiredVariable(t *testing.T) {
+	os.Unsetenv("FIGTREE_TEST_PASSWORD")
+
+	_, err := figtree.ParseString("key1 value1\npassword ${FIGTREE_TEST_PASSWORD:?must be set}\n", figtree.WithEnvExpansion())
+	if !errors.Is(err, figtree.ErrRequiredVariable) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrRequiredVariable, err)
+	}
+	expected := "string:2:10: no value for 'FIGTREE_TEST_PASSWORD': must be set"
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+
+	_, err = figtree.ParseString("key ${UNCLOSED\n", figtree.WithEnvExpansion())
+	if !errors.Is(err, figtree.ErrSyntax) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrSyntax, err)
+	}
+}
+
+func TestWithoutEnvExpansion(t *testing.T) {
+	os.Setenv("FIGTREE_TEST_HOME", "/home/fig")
+	defer os.Unsetenv("FIGTREE_TEST_HOME")
+
+	// without the option, and whatever other options are given, values are kept exactly as written
+	input := "home ${FIGTREE_TEST_HOME}\npassword pa$$word\nunclosed ${UNCLOSED\n"
+	for _, options := range [][]figtree.ReadOption{nil, {figtree.WithoutFilePragmas()}} {
+		root, err := figtree.ParseString(input, options...)
+		if err != nil {
+			t.Fatalf("expected 'nil', got '%v'", err)
+		}
+		tests := map[string]string{
+			"home":     "${FIGTREE_TEST_HOME}",
+			"password": "pa$$word",
+			"unclosed": "${UNCLOSED",
+		}
+		for keyPath, expected := range tests {
+			actual, err := root.GetValue(keyPath)
+			if err != nil || expected != actual {
+				t.Errorf("%s: expected '%s', got '%s' (%v)", keyPath, expected, actual, err)
+			}
+		}
+	}
+}
+
+func TestWriteLiteralDollarSigns(t *testing.T) {
+	os.Setenv("FIGTREE_TEST_HOME", "/home/fig")
+	defer os.Unsetenv("FIGTREE_TEST_HOME")
+
+	root, err := figtree.ParseString("cost ${FIGTREE_TEST_HOME}\nhome ${FIGTREE_TEST_HOME}\n", figtree.WithEnvExpansion())
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	item, _ := root.GetItem("cost")
+	item.SetValue("cost $$5 ${FIGTREE_TEST_HOME}")
+	root.AppendItem(figtree.NewItem("note", "$ alone"))
+
+	// the value set in code is written as it is, unless it is to be read back with expansion
+	wf := figtree.WriteFigtree{}
+	buf, _ := root.WriteToBuffer(wf)
+	expected := "cost cost $$5 ${FIGTREE_TEST_HOME}\nhome ${FIGTREE_TEST_HOME}\nnote $ alone\n"
+	if expected != buf {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf)
+	}
+
+	// the value set in code is escaped, while the template read from the file is kept as it was
+	wf = figtree.WriteFigtree{EnvExpansion: true}
+	buf, _ = root.WriteToBuffer(wf)
+	expected = "cost cost $$$$5 $${FIGTREE_TEST_HOME}\nhome ${FIGTREE_TEST_HOME}\nnote $ alone\n"
+	if expected != buf {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf)
+	}
+
+	reread, err := figtree.ParseString(buf, figtree.WithEnvExpansion())
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	tests := map[string]string{
+		"cost": "cost $$5 ${FIGTREE_TEST_HOME}",
+		"home": "/home/fig",
+		"note": "$ alone",
+	}
+	for keyPath, expected := range tests {
+		actual, err := reread.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", keyPath, expected, actual, err)
+		}
+	}
+}
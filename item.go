@@ -1,8 +1,9 @@
 //=============================================================================
 // File:     item.go
 // Contents: Item type declaration
+//           NewUnsetItem
 //           Copy constructor
-//           Type, Key, SetKey, Value, SetValue, Branch, SetBranch
+//           Type, IsUnset, Key, SetKey, Value, RawValue, SetValue, Branch, SetBranch
 //=============================================================================
 
 package figtree
@@ -13,41 +14,79 @@ package figtree
 //
 // Private fields
 //
+// The rawValue field contains the value as written in the file, before any ${NAME} or ${key/path} references were expanded,
+// or an empty string when the value contains no such references.
 // The blockComments field contains any empty lines or block comment lines that immediately preceed this item.
 // The terminalWhitespace field is a string containing the tabs and spaces that separate the value and the terminalComment, if any.
 // The terminalComment field contains any comment situated on the same line, to the right of the item's value.
 // The srcFile, srcLine, and srcOrigin fields reference the source's filename, line number, and type of origin.
+// The conditional field is the branch of the taken !if or !else block that the item was copied from,
+// if any. WriteFigtree writes the copy within the block, in place of the original, so that a change
+// made through the access functions is written back out.
+// The conditionalTaken field is true for the branch item of a taken !if or !else block.
+// The profile field is the name of the profile block that the item was read from, if any.
+// The overridden field holds the items that a selected profile's item replaced when it was merged,
+// which WriteFigtree writes in its place, so that the file reads back the same without the profile.
+// The removed field holds the items that an !unset item removed when it was merged, for WriteInternal to show.
+// The mergeAppend field is true when the key was written with a + prefix, which appends the item to an array when merging.
+// The literalValue field is true when the value was set by NewItem or SetValue rather than read from a file,
+// so that WriteFigtree escapes any dollar sign that would otherwise be expanded when the value is read back.
 type Item struct {
 	key                string
 	value              interface{}
+	rawValue           string
 	blockComments      []string
 	terminalWhitespace string
 	terminalComment    string
 	srcFile            string
 	srcLine            int
 	srcOrigin          FileOrigin
+	conditional        *Branch
+	conditionalTaken   bool
+	profile            string
+	overridden         []Item
+	removed            []Item
+	mergeAppend        bool
+	literalValue       bool
 }
 
 // Allocate and initialize a new item.
 func NewItem(key string, value string) Item {
 	newItem := Item{
-		key:   key,
-		value: value,
+		key:          key,
+		value:        value,
+		literalValue: true,
 	}
 	return newItem
 }
 
+// Allocate and initialize an item that marks the item at the given keyPath for removal.
+// The keyPath is relative to the branch that the marker is added to. When the branch is merged
+// over another with Merge, the item at the keyPath, or every item of an array, is removed from the
+// merged result. The marker is the same as an !unset pragma read from a file.
+func NewUnsetItem(keyPath string) Item {
+	return NewItem(unsetPragma, keyPath)
+}
+
 // Make a copy of an item.
 func (item Item) Copy() Item {
 	newItem := Item{
 		key:                item.key,
 		value:              item.value,
+		rawValue:           item.rawValue,
 		blockComments:      item.blockComments,
 		terminalWhitespace: item.terminalWhitespace,
 		terminalComment:    item.terminalComment,
 		srcFile:            item.srcFile,
 		srcLine:            item.srcLine,
 		srcOrigin:          item.srcOrigin,
+		conditional:        item.conditional,
+		conditionalTaken:   item.conditionalTaken,
+		profile:            item.profile,
+		overridden:         item.overridden,
+		removed:            item.removed,
+		mergeAppend:        item.mergeAppend,
+		literalValue:       item.literalValue,
 	}
 	return newItem
 }
@@ -78,6 +117,13 @@ func (item Item) ItemCount() int {
 	}
 }
 
+// Determine whether the item marks another for removal, as an !unset pragma, or an item
+// created with NewUnsetItem, does.
+func (item Item) IsUnset() bool {
+	_, isLeaf := item.value.(string)
+	return item.key == unsetPragma && isLeaf
+}
+
 // Get the item's key.
 func (item Item) Key() string {
 	return item.key
@@ -101,9 +147,23 @@ func (item Item) Value() (string, error) {
 	return "", ErrNotLeaf
 }
 
-// Change the item's value.
+// Get the item's value as written in the file, before any ${NAME} or ${key/path} references were expanded.
+// Value returns the resolved value. When the value contains no such references, the two are the same.
+//
+// Returns ErrNotLeaf if the item holds a branch pointer rather than a leaf value.
+func (item Item) RawValue() (string, error) {
+	if item.rawValue != "" {
+		return item.rawValue, nil
+	}
+	return item.Value()
+}
+
+// Change the item's value. Any raw value read from the file is discarded, and the value is
+// written by WriteFigtree exactly as given, with any dollar sign escaped when it would otherwise be expanded.
 func (item *Item) SetValue(value string) {
 	item.value = value
+	item.rawValue = ""
+	item.literalValue = true
 }
 
 // Get the item's branch pointer.
@@ -0,0 +1,108 @@
+//=============================================================================
+// File:     limits.go
+// Contents: Limit enum declaration
+//           LimitError type declaration
+//           Enforcement of the resource limits set by reader options
+//=============================================================================
+
+package figtree
+
+import (
+	"fmt"
+	"io"
+)
+
+// The Limit type identifies one of the resource limits that a read can enforce
+// when the configuration comes from an untrusted source.
+type Limit int
+
+const (
+	LimitFileSize    Limit = iota // the number of bytes in a file, set with WithMaxFileSize
+	LimitLineLength               // the number of bytes in a line, set with WithMaxLineLength
+	LimitDepth                    // the number of nested branches, set with WithMaxDepth
+	LimitItemCount                // the number of items in all files, set with WithMaxItems
+	LimitFilePragmas              // the ban on pragmas that read other files, set with WithoutFilePragmas
+)
+
+func (limit Limit) String() string {
+	return [...]string{"file size", "line length", "nesting depth", "item count", "file pragma"}[limit]
+}
+
+// The default limit on how deeply branches may be nested, which keeps the recursive
+// parser from exhausting the stack.
+const defaultMaxDepth = 1000
+
+// The default limit on the number of bytes in a line, which is large enough for embedded
+// certificates and base64 blobs, but keeps a file without line breaks from exhausting memory.
+const defaultMaxLineLength = 16 << 20
+
+// The LimitError type is returned when a read exceeds one of its resource limits.
+// Unlike syntax errors, these are never collected as diagnostics by a tolerant read.
+// It matches ErrLimitExceeded when tested with errors.Is.
+type LimitError struct {
+	Limit   Limit  // the limit that was exceeded
+	Max     int64  // the value of the limit, or zero for LimitFilePragmas
+	SrcFile string // the file being read
+	SrcLine int    // the 1-based line number where the limit was exceeded, or zero when not known
+	Pragma  string // the pragma that was refused, for LimitFilePragmas
+}
+
+// Formats the error as "srcFile:srcLine: " followed by a description of the limit.
+func (e *LimitError) Error() string {
+	position := e.SrcFile
+	if e.SrcLine > 0 {
+		position = fmt.Sprintf("%s:%d", e.SrcFile, e.SrcLine)
+	}
+	if e.Limit == LimitFilePragmas {
+		return fmt.Sprintf("%s: the %s pragma is not allowed", position, e.Pragma)
+	}
+	return fmt.Sprintf("%s: %v limit of %d exceeded", position, e.Limit, e.Max)
+}
+
+// Returns ErrLimitExceeded.
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// The sizeLimitedReader type returns an error, rather than more data, once the limit is passed.
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64 // the number of bytes that may still be read
+	err       error // the error to return once the limit has been passed
+}
+
+func (lr *sizeLimitedReader) Read(p []byte) (int, error) {
+	if lr.remaining < 0 {
+		return 0, lr.err
+	}
+	// read at most one byte more than the limit, to detect that it has been passed
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+	n, err := lr.r.Read(p)
+	lr.remaining -= int64(n)
+	if lr.remaining < 0 {
+		return 0, lr.err
+	}
+	return n, err
+}
+
+// Count one more item, for a line that begins a branch or holds a key/value pair.
+//
+// Returns a *LimitError when the number of items in all files read so far exceeds the limit.
+func (ctx *readContext) countItem(srcFile string, srcLine int) error {
+	ctx.itemCount++
+	if ctx.maxItems > 0 && ctx.itemCount > ctx.maxItems {
+		return &LimitError{Limit: LimitItemCount, Max: int64(ctx.maxItems), SrcFile: srcFile, SrcLine: srcLine}
+	}
+	return nil
+}
+
+// Determine whether the key is a pragma that reads another file.
+func isFilePragma(key string) bool {
+	switch key {
+	case "!include", "!include?", "!include-as", "!include-dir", "!baseline", "!dtd":
+		return true
+	}
+	return false
+}
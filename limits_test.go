@@ -0,0 +1,123 @@
+//=============================================================================
+// File:     limits_test.go
+// Tests:    File size, line length, nesting depth, and item count limits
+//           WithoutFilePragmas
+//           The default nesting depth limit
+//           Lines longer than bufio.MaxScanTokenSize
+//           Scanner errors are surfaced with their position
+//=============================================================================
+
+package figtree_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/readwritepro/figtree"
+)
+
+func TestLimits(t *testing.T) {
+	input := "key1 value1\nsection {\n\tinner {\n\t\tkey2 a-rather-longer-value\n\t}\n}\nkey3 value3\n"
+	if _, err := figtree.ParseString(input); err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	tests := []struct {
+		option   figtree.ReadOption
+		expected string
+	}{
+		{figtree.WithMaxFileSize(20), "string: file size limit of 20 exceeded"},
+		{figtree.WithMaxLineLength(16), "string:4: line length limit of 16 exceeded"},
+		{figtree.WithMaxDepth(1), "string:3: nesting depth limit of 1 exceeded"},
+		{figtree.WithMaxItems(4), "string:7: item count limit of 4 exceeded"},
+	}
+	for _, test := range tests {
+		_, err := figtree.ParseString(input, test.option)
+		var limitErr *figtree.LimitError
+		if !errors.As(err, &limitErr) || !errors.Is(err, figtree.ErrLimitExceeded) {
+			t.Errorf("expected a *LimitError, got '%v'", err)
+			continue
+		}
+		if test.expected != err.Error() {
+			t.Errorf("expected '%s', got '%v'", test.expected, err)
+		}
+	}
+
+	// the limits are never tolerated
+	for _, option := range []figtree.ReadOption{figtree.WithMaxDepth(1), figtree.WithoutFilePragmas()} {
+		_, diagnostics := figtree.ReadFigtreeFromTolerant(strings.NewReader("!if x {\n\t!include a.fig\n\tsection {\n\t}\n}\n"), "string", option)
+		if len(diagnostics) != 1 || !errors.Is(diagnostics[0].Err, figtree.ErrLimitExceeded) {
+			t.Errorf("expected a single '%v', got %v", figtree.ErrLimitExceeded, diagnostics)
+		}
+	}
+}
+
+func TestWithoutFilePragmas(t *testing.T) {
+	for _, pragma := range []string{"!include", "!include?", "!include-dir", "!baseline", "!dtd"} {
+		_, err := figtree.ParseString("key1 value1\n"+pragma+" other.fig\n", figtree.WithoutFilePragmas())
+		var limitErr *figtree.LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != figtree.LimitFilePragmas || limitErr.SrcLine != 2 {
+			t.Errorf("%s: expected a *LimitError on line 2, got '%v'", pragma, err)
+		}
+	}
+}
+
+func TestDefaultMaxDepth(t *testing.T) {
+	input := strings.Repeat("section {\n", 1001) + strings.Repeat("}\n", 1001)
+	_, err := figtree.ParseString(input)
+	if !errors.Is(err, figtree.ErrLimitExceeded) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrLimitExceeded, err)
+	}
+	_, err = figtree.ParseString(input, figtree.WithMaxDepth(0))
+	if err != nil {
+		t.Errorf("expected 'nil', got '%v'", err)
+	}
+}
+
+func TestLongLines(t *testing.T) {
+	blob := strings.Repeat("QUJD", 50000) // 200,000 bytes
+	input := "key1 value1\nblob " + blob + "\nkey2 value2\n"
+
+	for _, options := range [][]figtree.ReadOption{nil, {figtree.WithMaxLineLength(0)}} {
+		root, err := figtree.ParseString(input, options...)
+		if err != nil {
+			t.Fatalf("expected 'nil', got '%v'", err)
+		}
+		actual, _ := root.GetValue("blob")
+		if blob != actual {
+			t.Errorf("expected a value of %d bytes, got %d bytes", len(blob), len(actual))
+		}
+	}
+
+	_, err := figtree.ParseString(input, figtree.WithMaxLineLength(100000))
+	expected := "string:2: line length limit of 100000 exceeded"
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+
+	// a scanner created by the caller keeps its own buffer size, and its error is reported with the line
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	srcLine := 0
+	err = figtree.NewBranch().ParseBranch(scanner, "string", &srcLine, figtree.UserFile)
+	if !errors.Is(err, bufio.ErrTooLong) || !strings.HasPrefix(err.Error(), "string:2: ") {
+		t.Errorf("expected 'string:2: %v', got '%v'", bufio.ErrTooLong, err)
+	}
+}
+
+func TestScannerError(t *testing.T) {
+	r := io.MultiReader(strings.NewReader("key1 value1\nsection {\n"), &failingReader{})
+	_, err := figtree.ReadFigtreeFrom(r, "failing")
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected '%v', got '%v'", io.ErrUnexpectedEOF, err)
+	}
+}
+
+// The failingReader type returns an error on every read.
+type failingReader struct{}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
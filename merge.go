@@ -3,10 +3,118 @@
 // Contents: Merge a user config file with baseline file containing fallback defaults
 //           Copy constructor for branches
 //           Merge function to
+//           Removal of items marked by the !unset pragma
+//           MergeMode enum declaration
+//           NewMergeItem
 //=============================================================================
 
 package figtree
 
+import (
+	"strings"
+)
+
+// The key of the pragma, and of the item created by NewUnsetItem, that removes an item when merging.
+const unsetPragma = "!unset"
+
+// The key of the pragma, and of the item created by NewMergeItem, that sets how arrays are merged.
+const mergePragma = "!merge"
+
+// The MergeMode type determines how the items of an array are merged with the items
+// of the same array in the branch they are merged over.
+type MergeMode int
+
+const (
+	MergeReplace MergeMode = iota // the items replace every item of the array, which is the default
+	MergeAppend                   // the items are added after the items of the array
+	MergePrepend                  // the items are added before the items of the array
+)
+
+func (mode MergeMode) String() string {
+	return [...]string{"replace", "append", "prepend"}[mode]
+}
+
+// Allocate and initialize an item that sets the merge mode of the branch it is added to,
+// in the same way as a !merge pragma read from a file. When keyNames are given, the mode
+// applies only to the arrays with those keys; otherwise it applies to every array in the branch.
+func NewMergeItem(mode MergeMode, keyNames ...string) Item {
+	return NewItem(mergePragma, strings.Join(append([]string{mode.String()}, keyNames...), " "))
+}
+
+// Separate the value of a !merge pragma into its mode and the keys it applies to.
+//
+// Returns false when the value does not begin with append, prepend, or replace.
+func parseMergeDirective(value string) (MergeMode, []string, bool) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return MergeReplace, nil, false
+	}
+	for _, mode := range []MergeMode{MergeReplace, MergeAppend, MergePrepend} {
+		if fields[0] == mode.String() {
+			return mode, fields[1:], true
+		}
+	}
+	return MergeReplace, nil, false
+}
+
+// Separate the + prefix, which appends an item to an array when merging, from a key.
+// Pragmas never have the prefix.
+func splitAppendPrefix(key string) (string, bool) {
+	if len(key) > 1 && key[0] == '+' && key[1] != '!' {
+		return key[1:], true
+	}
+	return key, false
+}
+
+// Find the merge mode of the array with the given key, as set by the !merge items of the branch.
+// A !merge item naming the key takes precedence over one that applies to every array.
+func (branch *Branch) mergeMode(keyName string) MergeMode {
+	branchMode, keyMode := MergeReplace, MergeReplace
+	named := false
+	for _, item := range branch.Items {
+		value, isLeaf := item.value.(string)
+		if item.key != mergePragma || !isLeaf {
+			continue
+		}
+		mode, keyNames, ok := parseMergeDirective(value)
+		if !ok {
+			continue
+		}
+		if len(keyNames) == 0 {
+			branchMode = mode
+		}
+		for _, name := range keyNames {
+			if name == keyName {
+				keyMode = mode
+				named = true
+			}
+		}
+	}
+	if named {
+		return keyMode
+	}
+	return branchMode
+}
+
+// Determine whether a !merge item of the branch names the given key, which makes the item an array
+// even when it appears only once.
+func (branch *Branch) mergeNamesKey(keyName string) bool {
+	for _, item := range branch.Items {
+		value, isLeaf := item.value.(string)
+		if item.key != mergePragma || !isLeaf {
+			continue
+		}
+		if _, keyNames, ok := parseMergeDirective(value); ok {
+			for _, name := range keyNames {
+				if name == keyName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
 // Merge the baseline tree with the user's tree. The baseline may be nil.
 func mergeBaselineWithUser(baselineTree *Branch, userTree *Branch) *Branch {
 	if baselineTree == nil {
@@ -21,7 +129,9 @@ func mergeBaselineWithUser(baselineTree *Branch, userTree *Branch) *Branch {
 // Make a copy of the branch by copying the items of the current branch
 func (branch *Branch) Copy() *Branch {
 	newBranch := Branch{
-		Items: make([]Item, 0, len(branch.Items)),
+		Items:      make([]Item, 0, len(branch.Items)),
+		lineEnding: branch.lineEnding,
+		metadata:   branch.metadata,
 	}
 	for _, item := range branch.Items {
 		newItem := item.Copy()
@@ -35,78 +145,175 @@ func (branch *Branch) Copy() *Branch {
 // Typically the dstBranch object is the baseline tree containing fallback values
 // and the srcBranch is the user's config tree containing explicit overrides.
 //
+// An !unset item in the srcBranch removes the item at its keyPath from the dstBranch.
+// Items are merged in order, so an item following the !unset item may add the key back.
+//
+// Arrays are replaced, unless a !merge item in the srcBranch sets the mode for the array to
+// append or prepend. An item whose key was written with a + prefix is always appended.
+// Scalars and branches are merged as usual whatever the mode, and the !merge items of the
+// srcBranch replace those of the dstBranch.
+//
 // This is a public function and may be called programmatically, but it rarely is.
 // This function is called by ReadConfig in order to merge a baseline config
 // with a user's config.
 func (dstBranch *Branch) Merge(srcBranch *Branch) {
 
 	alreadySeen := make(map[string]bool)
+	hasMergeItems := srcBranch.ItemExists(mergePragma)
+	copies := make(map[*Branch]*Branch) // the dstBranch's counterpart of each srcBranch branch
 
 	for _, srcItem := range srcBranch.Items {
 		key := srcItem.key
-		if srcBranch.ItemIsArray(key) || dstBranch.ItemIsArray(key) {
+		if srcItem.IsUnset() {
+			dstBranch.mergeUnsetItem(srcItem)
+		} else if key == mergePragma {
+			_, exists := alreadySeen[key]
+			if !exists {
+				mergeItems := srcBranch.QueryAll(key)
+				if dstBranch.ItemExists(key) {
+					if mergeItems[0].profile != "" {
+						mergeItems[0].overridden = dstBranch.QueryAll(key)
+					}
+					dstBranch.replaceArrayItems(key, mergeItems)
+				} else {
+					dstBranch.Items = append(dstBranch.Items, mergeItems...)
+				}
+			}
+			alreadySeen[key] = true
+		} else if srcBranch.ItemIsArray(key) || dstBranch.ItemIsArray(key) || srcItem.mergeAppend || (hasMergeItems && srcBranch.mergeNamesKey(key)) {
 			_, exists := alreadySeen[key]
 			if !exists {
 				dstBranch.mergeArrayItems(srcBranch, key)
 			}
 			alreadySeen[key] = true
 		} else {
-			dstBranch.mergeScalarItem(srcItem)
+			dstBranch.mergeScalarItem(srcItem, copies)
+		}
+	}
+
+	// the copies of the items of a taken conditional block belong to the dstBranch's counterpart of the block
+	for i := range dstBranch.Items {
+		if block, ok := copies[dstBranch.Items[i].conditional]; ok {
+			dstBranch.Items[i].conditional = block
 		}
 	}
 }
 
 // Merge the srcItem into the dstBranch. Override any existing value with
 // the srcItem's value. If the destination branch does not have an item
-// with a matching keyName, append a copy of the srcItem.
-func (dstBranch *Branch) mergeScalarItem(srcItem Item) {
+// with a matching keyName, append a copy of the srcItem, with a deep copy of its branch, if any,
+// so that the source is never merged into itself. The copies map records the counterpart
+// of each source branch in the destination. A leaf replaced by a profile's item is kept
+// with the item, so that WriteFigtree can write it.
+func (dstBranch *Branch) mergeScalarItem(srcItem Item, copies map[*Branch]*Branch) {
 	var dstItem *Item
 
 	// if the destination already has an item with this key
 	if dstBranch.ItemExists(srcItem.key) {
 		item, _ := dstBranch.GetItem(srcItem.key)
-		if item.Type() == "[leaf]" {
+		isLeaf := item.Type() == "[leaf]"
+		if isLeaf {
+			// keep the item that a profile replaces, for WriteFigtree
+			if srcItem.profile != "" && item.profile == "" {
+				item.overridden = []Item{item.Copy()}
+			}
 			item.value = srcItem.value
+			item.rawValue = srcItem.rawValue
+			item.literalValue = srcItem.literalValue
+			item.conditional = srcItem.conditional
+		}
+		// a branch that a profile is merged into remains the one written in the enclosing branch
+		if isLeaf || srcItem.profile == "" {
+			item.blockComments = srcItem.blockComments
+			item.terminalWhitespace = srcItem.terminalWhitespace
+			item.terminalComment = srcItem.terminalComment
+			item.srcFile = srcItem.srcFile
+			item.srcLine = srcItem.srcLine
+			item.srcOrigin = srcItem.srcOrigin
+			item.profile = srcItem.profile
 		}
-		item.blockComments = srcItem.blockComments
-		item.terminalWhitespace = srcItem.terminalWhitespace
-		item.terminalComment = srcItem.terminalComment
-		item.srcFile = srcItem.srcFile
-		item.srcLine = srcItem.srcLine
-		item.srcOrigin = srcItem.srcOrigin
 		dstItem = item
 
 	} else {
-		// if the destination doesn't have an item with this key
+		// if the destination doesn't have an item with this key, there is nothing to merge with
 		dupItem := srcItem.Copy()
+		if innerSrc, ok := srcItem.value.(*Branch); ok {
+			innerDup := innerSrc.deepCopyShared(copies)
+			innerDup.clearMergeAppend()
+			dupItem.value = innerDup
+		}
 		dstBranch.Items = append(dstBranch.Items, dupItem)
-		dstItem = &dupItem
+		return
 	}
 	// recurse branches
 	if srcItem.Type() == "[branch]" {
 		innerDst := dstItem.value.(*Branch)
 		innerSrc := srcItem.value.(*Branch)
+		copies[innerSrc] = innerDst
 		innerDst.Merge(innerSrc)
 	}
 
 }
 
+// Clear the + prefix of every item in the branch and its inner branches, which have been
+// appended to a destination that has nothing for them to be appended to.
+func (branch *Branch) clearMergeAppend() {
+	for i := range branch.Items {
+		branch.Items[i].mergeAppend = false
+		if innerBranch, ok := branch.Items[i].value.(*Branch); ok {
+			innerBranch.clearMergeAppend()
+		}
+	}
+}
+
 // Merge the items with the given keyName. If items are only present in one of the two branches
 // keep those items. If items are present in both branches, discard all of the dstBranch items
-// and replace them with the srcBranch items.
+// and replace them with the srcBranch items, unless the srcBranch sets the array's merge mode
+// to append or prepend, in which case the srcBranch items are added after or before them.
+// Items written with a + prefix are added after the others.
 // The dstBranch is typically a branch of the baselineTree
 // The srcBranch is typically a branch of the userTree
 func (dstBranch *Branch) mergeArrayItems(srcBranch *Branch, keyName string) {
 	dstItems := dstBranch.QueryAll(keyName)
-	srcItems := srcBranch.QueryAll(keyName)
+	srcItems := make([]Item, 0)
+	appendedItems := make([]Item, 0)
+	for _, item := range srcBranch.QueryAll(keyName) {
+		if item.mergeAppend {
+			item.mergeAppend = false
+			appendedItems = append(appendedItems, item)
+		} else {
+			srcItems = append(srcItems, item)
+		}
+	}
 
-	if len(srcItems) == 0 {
+	if len(srcItems) == 0 && len(appendedItems) == 0 {
 		return
 	}
 	if len(dstItems) == 0 {
 		dstBranch.Items = append(dstBranch.Items, srcItems...)
+		dstBranch.Items = append(dstBranch.Items, appendedItems...)
+		return
+	}
+
+	// add the srcBranch items where the dstBranch items were
+	mode := srcBranch.mergeMode(keyName)
+	if len(srcItems) == 0 || mode != MergeReplace {
+		mergedItems := make([]Item, 0, len(dstItems)+len(srcItems)+len(appendedItems))
+		if mode == MergePrepend {
+			mergedItems = append(mergedItems, srcItems...)
+		}
+		mergedItems = append(mergedItems, dstItems...)
+		if mode != MergePrepend {
+			mergedItems = append(mergedItems, srcItems...)
+		}
+		mergedItems = append(mergedItems, appendedItems...)
+		dstBranch.replaceArrayItems(keyName, mergedItems)
 		return
 	}
+	srcItems = append(srcItems, appendedItems...)
+	if srcItems[0].profile != "" {
+		srcItems[0].overridden = dstItems
+	}
 	for {
 		err := dstBranch.RemoveItem(keyName)
 		if err == ErrNotFound {
@@ -115,3 +322,72 @@ func (dstBranch *Branch) mergeArrayItems(srcBranch *Branch, keyName string) {
 	}
 	dstBranch.Items = append(dstBranch.Items, srcItems...)
 }
+
+// Replace every item with the given keyName by the given items, which are placed where the first of them was.
+func (branch *Branch) replaceArrayItems(keyName string, items []Item) {
+	newItems := make([]Item, 0, len(branch.Items)+len(items))
+	for _, item := range branch.Items {
+		if item.key != keyName {
+			newItems = append(newItems, item)
+		} else if items != nil {
+			newItems = append(newItems, items...)
+			items = nil
+		}
+	}
+	branch.Items = newItems
+}
+
+// Remove the item at the keyPath held by the srcItem, which is an !unset item, from the dstBranch,
+// then append a copy of the srcItem, recording the items that were removed, so that the merged
+// result shows the removal. A keyPath that does not exist in the dstBranch removes nothing.
+// The copy of a profile's item takes the place of the items it removes instead.
+func (dstBranch *Branch) mergeUnsetItem(srcItem Item) {
+	keyPath := strings.Trim(srcItem.value.(string), " \t")
+	branch := dstBranch
+	key := keyPath
+	if slash := strings.LastIndex(keyPath, "/"); slash != -1 {
+		key = keyPath[slash+1:]
+		innerBranch, err := dstBranch.GetBranch(keyPath[:slash])
+		if err != nil {
+			branch = nil
+		} else {
+			branch = innerBranch
+		}
+	}
+
+	// a profile's item is kept in the branch that it removes from, in place of the items it
+	// removes, so that WriteFigtree can write them
+	if srcItem.profile != "" && branch != nil && branch != dstBranch {
+		innerItem := srcItem.Copy()
+		innerItem.value = key
+		innerItem.rawValue = ""
+		branch.mergeUnsetItem(innerItem)
+		return
+	}
+
+	removed := make([]Item, 0)
+	position := -1
+	if branch != nil {
+		keptItems := make([]Item, 0, len(branch.Items))
+		for _, item := range branch.Items {
+			if item.key == key {
+				if position == -1 {
+					position = len(keptItems)
+				}
+				removed = append(removed, item)
+			} else {
+				keptItems = append(keptItems, item)
+			}
+		}
+		branch.Items = keptItems
+	}
+
+	unsetItem := srcItem.Copy()
+	unsetItem.removed = removed
+	if srcItem.profile != "" && len(removed) > 0 {
+		unsetItem.overridden = removed
+		dstBranch.Items = append(dstBranch.Items[:position], append([]Item{unsetItem}, dstBranch.Items[position:]...)...)
+		return
+	}
+	dstBranch.Items = append(dstBranch.Items, unsetItem)
+}
@@ -3,12 +3,22 @@
 // Tests:    Read user file with !baseline pragma
 //           Sort items
 //           Write merged baseline + user file
+//           The !unset pragma removes baseline items and branches
+//           Merge honors items created with NewUnsetItem
+//           An !unset item within a branch missing from the baseline is kept once
+//           Arrays are appended or prepended by + keys and !merge pragmas
+//           Merge honors items created with NewMergeItem
+//           A + key within a branch missing from the baseline is merged once
+//           A branch-wide !merge mode leaves scalars and branches to the usual merge
 //=============================================================================
 
 package figtree_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/readwritepro/compare-test-results"
 	"github.com/readwritepro/figtree"
@@ -39,3 +49,242 @@ func TestMerge(t *testing.T) {
 	}
 	compare.ExpectedActual(t, "testdata/expected/user-figtree", "testdata/actual/user-figtree")
 }
+
+func TestUnset(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.fig": {Data: []byte("port 8080\nsection2 {\n\titem2-1 one\n\titem2-2 two\n\titem2-3 three\n}\nns ns1\nns ns2\nlegacy {\n\tkey value\n}\n")},
+		"user.fig": {Data: []byte("!baseline base.fig\n!unset section2/item2-1\n!unset ns\n!unset legacy\n!unset nonexistent/key\nsection2 {\n\t!unset item2-2\n}\n!unset port\nport 443\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "user.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	for _, keyPath := range []string{"section2/item2-1", "section2/item2-2", "ns", "legacy"} {
+		if root.PathExists(keyPath) {
+			t.Errorf("expected '%s' to be removed", keyPath)
+		}
+	}
+	tests := map[string]string{
+		"section2/item2-3": "three",
+		"port":             "443",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", keyPath, expected, actual, err)
+		}
+	}
+
+	// the removals are shown with the source of the items that were removed
+	wi := figtree.WriteInternal{}
+	buf, _ := root.WriteToBuffer(wi)
+	for _, expected := range []string{
+		"(User)[user.fig:2]               !unset section2/item2-1 (removed (Base)[base.fig:3])\n",
+		"(User)[user.fig:3]               !unset ns (removed (Base)[base.fig:7]) (removed (Base)[base.fig:8])\n",
+		"(User)[user.fig:5]               !unset nonexistent/key\n",
+		"(User)[user.fig:7]              \t !unset item2-2 (removed (Base)[base.fig:4])\n",
+	} {
+		if !strings.Contains(buf, expected) {
+			t.Errorf("expected\n%s\nwithin\n%s", expected, buf)
+		}
+	}
+}
+
+func TestMergeUnsetItem(t *testing.T) {
+	baseline, err := figtree.ParseString("host localhost\nport 8080\n")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	user := figtree.NewBranch()
+	unsetItem := figtree.NewUnsetItem("port")
+	if !unsetItem.IsUnset() {
+		t.Errorf("expected the item to be an !unset item")
+	}
+	user.AppendItem(unsetItem)
+	baseline.Merge(user)
+
+	if baseline.ItemExists("port") {
+		t.Errorf("expected 'port' to be removed")
+	}
+	wf := figtree.WriteFigtree{}
+	buf, _ := baseline.WriteToBuffer(wf)
+	expected := "host localhost\n!unset port\n"
+	if expected != buf {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf)
+	}
+}
+
+func TestUnsetInMissingBranch(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.fig": {Data: []byte("ns a\n")},
+		"user.fig": {Data: []byte("!baseline base.fig\nsection {\n\t!unset gone\n\tkey value\n}\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "user.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	if actual := len(root.QueryAll("section/!unset")); actual != 1 {
+		t.Errorf("expected 1 '!unset' item, got %d", actual)
+	}
+	wf := figtree.WriteFigtree{}
+	buf, _ := root.WriteToBuffer(wf)
+	expected := "ns a\n!baseline base.fig\nsection {\n\t!unset gone\n\tkey value\n}\n"
+	if expected != buf {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf)
+	}
+
+	// merging the result at another level does not repeat the item, nor alter the branch that was merged
+	user, _ := root.GetBranch("section")
+	merged := figtree.NewBranch()
+	merged.Merge(root)
+	if actual := len(merged.QueryAll("section/!unset")); actual != 1 {
+		t.Errorf("expected 1 '!unset' item, got %d", actual)
+	}
+	if user.ItemCount() != 2 {
+		t.Errorf("expected the merged branch to keep 2 items, got %d", user.ItemCount())
+	}
+}
+
+// Collect the values of every item with the given key.
+func arrayValues(branch *figtree.Branch, keyPath string) string {
+	values := make([]string, 0)
+	for _, item := range branch.QueryAll(keyPath) {
+		value, _ := item.Value()
+		values = append(values, value)
+	}
+	return strings.Join(values, " ")
+}
+
+func TestMergeDirectives(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.fig": {Data: []byte("ns ns1\nns ns2\nmx mx1\nmx mx2\nsearch a\nservers {\n\thost a\n\thost b\n}\n")},
+		"user.fig": {Data: []byte("!baseline base.fig\n+ns ns3\n!merge prepend mx\nmx mx0\nsearch b\n+search c\nservers {\n\t!merge append\n\thost c\n}\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "user.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	tests := map[string]string{
+		"ns":           "ns1 ns2 ns3",
+		"mx":           "mx0 mx1 mx2",
+		"search":       "b c",
+		"servers/host": "a b c",
+	}
+	for keyPath, expected := range tests {
+		actual := arrayValues(root, keyPath)
+		if expected != actual {
+			t.Errorf("%s: expected '%s', got '%s'", keyPath, expected, actual)
+		}
+	}
+
+	// the + prefix is written back out, and the key is accessible without a baseline
+	input := "+ns ns3\n+servers {\n\thost c\n}\n"
+	user, err := figtree.ParseString(input)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	if actual, _ := user.GetValue("ns"); actual != "ns3" {
+		t.Errorf("expected 'ns3', got '%s'", actual)
+	}
+	wf := figtree.WriteFigtree{}
+	buf, _ := user.WriteToBuffer(wf)
+	if input != buf {
+		t.Errorf("expected\n%s\ngot\n%s", input, buf)
+	}
+
+	_, err = figtree.ParseString("key value\n!merge sideways\n")
+	if !errors.Is(err, figtree.ErrSyntax) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrSyntax, err)
+	}
+	var parseErr *figtree.ParseError
+	if !errors.As(err, &parseErr) || parseErr.Reason != figtree.ReasonUnknownMergeMode {
+		t.Errorf("expected '%v', got '%v'", figtree.ReasonUnknownMergeMode, err)
+	}
+	expected := "string:2:8: unknown merge mode for '!merge': append, prepend, or replace is required"
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+}
+
+func TestMergeItemModes(t *testing.T) {
+	for mode, expected := range map[figtree.MergeMode]string{
+		figtree.MergeReplace: "ns3",
+		figtree.MergeAppend:  "ns1 ns2 ns3",
+		figtree.MergePrepend: "ns3 ns1 ns2",
+	} {
+		baseline, err := figtree.ParseString("ns ns1\nhost localhost\nns ns2\n")
+		if err != nil {
+			t.Fatalf("expected 'nil', got '%v'", err)
+		}
+		user := figtree.NewBranch()
+		user.AppendItem(figtree.NewMergeItem(mode, "ns"))
+		user.AppendItem(figtree.NewItem("ns", "ns3"))
+		baseline.Merge(user)
+
+		actual := arrayValues(baseline, "ns")
+		if expected != actual {
+			t.Errorf("%v: expected '%s', got '%s'", mode, expected, actual)
+		}
+	}
+}
+
+func TestAppendInMissingBranch(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.fig": {Data: []byte("ns a\n")},
+		"user.fig": {Data: []byte("!baseline base.fig\nsection {\n\t+ns x\n}\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "user.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	if actual := arrayValues(root, "section/ns"); actual != "x" {
+		t.Errorf("expected 'x', got '%s'", actual)
+	}
+	wf := figtree.WriteFigtree{}
+	buf, _ := root.WriteToBuffer(wf)
+	expected := "ns a\n!baseline base.fig\nsection {\n\tns x\n}\n"
+	if expected != buf {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf)
+	}
+
+	// the item has been appended, so merging the result over another baseline replaces that baseline's array
+	baseline, _ := figtree.ParseString("section {\n\tns b\n}\n")
+	baseline.Merge(root)
+	if actual := arrayValues(baseline, "section/ns"); actual != "x" {
+		t.Errorf("expected 'x', got '%s'", actual)
+	}
+}
+
+func TestMergeModeScalarsAndBranches(t *testing.T) {
+	expectedArrays := map[string]string{
+		"append":  "ns1 ns2 ns3",
+		"prepend": "ns3 ns1 ns2",
+	}
+	for mode, expected := range expectedArrays {
+		baseline, err := figtree.ParseString("!merge replace ns\ntimeout 10\nns ns1\nns ns2\nsection {\n\ta 1\n}\n")
+		if err != nil {
+			t.Fatalf("expected 'nil', got '%v'", err)
+		}
+		user, err := figtree.ParseString("!merge " + mode + "\ntimeout 30\nns ns3\nsection {\n\tb 2\n}\n")
+		if err != nil {
+			t.Fatalf("expected 'nil', got '%v'", err)
+		}
+		baseline.Merge(user)
+
+		if actual := arrayValues(baseline, "timeout"); actual != "30" {
+			t.Errorf("%s: expected '30', got '%s'", mode, actual)
+		}
+		if actual := len(baseline.QueryAll("section")); actual != 1 {
+			t.Errorf("%s: expected 1 'section' branch, got %d", mode, actual)
+		}
+		if actual := arrayValues(baseline, "section/a") + " " + arrayValues(baseline, "section/b"); actual != "1 2" {
+			t.Errorf("%s: expected '1 2', got '%s'", mode, actual)
+		}
+		if actual := arrayValues(baseline, "!merge"); actual != mode {
+			t.Errorf("%s: expected '%s', got '%s'", mode, mode, actual)
+		}
+		if actual := arrayValues(baseline, "ns"); actual != expected {
+			t.Errorf("%s: expected '%s', got '%s'", mode, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+//=============================================================================
+// File:     parse-error.go
+// Contents: ParseReason enum declaration
+//           ParseError type declaration
+//=============================================================================
+
+package figtree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The ParseReason type is a code describing why the parser rejected a line.
+type ParseReason int
+
+const (
+	ReasonUnexpectedClosingBrace ParseReason = iota // a closing brace without a matching opening brace
+	ReasonUnclosedBrace                             // an opening brace still open at the end of the file
+	ReasonUnterminatedQuote                         // a quoted value without a closing quotation mark
+	ReasonInvalidEscape                             // an unrecognized escape sequence within a quoted value
+	ReasonTextAfterQuote                            // something other than a terminal comment after a quoted value
+	ReasonUnterminatedHeredoc                       // a heredoc without a closing delimiter line
+	ReasonMalformedVariable                         // a ${...} reference that cannot be parsed
+	ReasonRequiredVariable                          // a ${NAME:?message} reference to a variable that is not set
+	ReasonMalformedCondition                        // an !if or !else pragma that cannot be parsed
+	ReasonElseWithoutIf                             // an !else pragma that does not follow an !if block
+	ReasonInvalidUTF8                               // a line containing bytes that are not valid UTF-8
+	ReasonUnknownPragma                             // a key beginning with an exclamation mark that is not a known pragma
+	ReasonEmptyKey                                  // a line without a key, in a strict read
+	ReasonUnwritableKey                             // a key that could not be written back out, in a strict read
+	ReasonDuplicateKey                              // a key repeated without being declared as an array, in a strict read
+	ReasonMalformedPragma                           // a pragma whose value cannot be parsed, such as !include-as without a filename
+	ReasonIncludeKeyPathNotFound                    // an include pragma's keyPath that does not name a branch of the included file
+	ReasonUnknownMergeMode                          // a !merge pragma whose mode is not append, prepend, or replace
+)
+
+func (reason ParseReason) String() string {
+	return [...]string{
+		"unexpected closing brace",
+		"unclosed opening brace",
+		"unterminated quoted value",
+		"invalid escape sequence",
+		"unexpected text after quoted value",
+		"unterminated heredoc",
+		"malformed variable reference",
+		"no value",
+		"malformed condition",
+		"!else without a preceding !if",
+		"invalid UTF-8",
+		"unknown pragma",
+		"empty key",
+		"key cannot be written back out",
+		"duplicate key",
+		"malformed pragma",
+		"keyPath not found",
+		"unknown merge mode",
+	}[reason]
+}
+
+// The sentinel error that a ParseError with this reason matches when tested with errors.Is.
+func (reason ParseReason) sentinel() error {
+	return [...]error{
+		ErrEndOfBranch,
+		ErrUnclosedBranch,
+		ErrSyntax,
+		ErrSyntax,
+		ErrSyntax,
+		ErrSyntax,
+		ErrSyntax,
+		ErrRequiredVariable,
+		ErrSyntax,
+		ErrSyntax,
+		ErrSyntax,
+		ErrSyntax,
+		ErrSyntax,
+		ErrSyntax,
+		ErrSyntax,
+		ErrSyntax,
+		ErrNotFound,
+		ErrSyntax,
+	}[reason]
+}
+
+// The ParseError type describes a syntax problem found while parsing figtree syntax.
+// It is returned by ReadConfig, ReadFigtree, and ParseBranch.
+//
+// Use errors.As to obtain the position of the problem, and errors.Is to compare
+// it against the sentinel matching its reason, such as ErrEndOfBranch.
+type ParseError struct {
+	SrcFile  string      // the name of the file being parsed
+	SrcLine  int         // the 1-based line number of the offending line
+	Column   int         // the 1-based column of the offending text within the line
+	LineText string      // the offending line, exactly as it appears in the file
+	Reason   ParseReason // the reason code
+	Key      string      // the key of the branch or pragma, the keyPath, or the name of the variable involved, if any
+	Detail   string      // any further explanation, such as the message of a ${NAME:?message} reference
+}
+
+// Formats the error as "srcFile:srcLine:column: reason", followed by the key and detail when there are any.
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.SrcFile, e.SrcLine, e.Column, e.message())
+}
+
+// Formats the reason, key, and detail without the position.
+func (e *ParseError) message() string {
+	msg := e.Reason.String()
+	if e.Key != "" {
+		msg += fmt.Sprintf(" for '%s'", e.Key)
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// Returns the sentinel error corresponding to the reason code.
+func (e *ParseError) Unwrap() error {
+	return e.Reason.sentinel()
+}
+
+// Create a ParseError for the given line, with the column pointing to the first occurrence of target.
+func newParseError(srcFile string, srcLine int, lineText string, target string, reason ParseReason) *ParseError {
+	column := 1
+	if pos := strings.Index(lineText, target); pos != -1 {
+		column = pos + 1
+	}
+	return &ParseError{
+		SrcFile:  srcFile,
+		SrcLine:  srcLine,
+		Column:   column,
+		LineText: lineText,
+		Reason:   reason,
+	}
+}
@@ -0,0 +1,203 @@
+//=============================================================================
+// File:     pragma.go
+// Contents: PragmaHandler type declaration
+//           RegisterPragma, UnregisterPragma
+//           PragmaContext type declaration
+//           PragmaError type declaration
+//=============================================================================
+
+package figtree
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// The PragmaHandler type is a function that acts upon a pragma registered with RegisterPragma.
+// It is called as the pragma is read, with the branch containing the pragma, the pragma's value,
+// and a PragmaContext describing where the pragma appears and the read that it is a part of.
+// The pragma itself has already been appended to the branch, so that WriteFigtree reproduces it.
+//
+// A handler may add items to the branch, record metadata with the PragmaContext, or return
+// an error to fail the parse. A tolerant read collects the error as a diagnostic and carries on.
+// Handlers may be called by several goroutines at the same time.
+type PragmaHandler func(branch *Branch, value string, pragma *PragmaContext) error
+
+// The pragmas that are handled by figtree itself, which can't be registered.
+var builtinPragmas = []string{"!include", "!include?", "!include-as", "!include-dir", "!baseline", "!dtd", "!if", "!else", "!array", "!unset", "!merge"}
+
+// The handlers registered with RegisterPragma, keyed by the pragma's name.
+var pragmaRegistry = struct {
+	sync.RWMutex
+	handlers map[string]PragmaHandler
+}{handlers: make(map[string]PragmaHandler)}
+
+// The RegisterPragma function adds a pragma, such as "!require-version", that is acted upon by
+// the given handler whenever it appears in a file read by this package. The name must begin with
+// an exclamation mark, must not contain whitespace, and must not be one of the built-in pragmas.
+//
+// Returns ErrInvalidPragma when the name is not allowed, or has already been registered.
+func RegisterPragma(name string, handler PragmaHandler) error {
+	if !strings.HasPrefix(name, "!") || len(name) == 1 || strings.ContainsAny(name, " \t{}\"#") {
+		return fmt.Errorf("%w: %q is not a pragma name", ErrInvalidPragma, name)
+	}
+	for _, builtin := range builtinPragmas {
+		if name == builtin {
+			return fmt.Errorf("%w: %s is a built-in pragma", ErrInvalidPragma, name)
+		}
+	}
+	if handler == nil {
+		return fmt.Errorf("%w: %s has no handler", ErrInvalidPragma, name)
+	}
+
+	pragmaRegistry.Lock()
+	defer pragmaRegistry.Unlock()
+	if _, ok := pragmaRegistry.handlers[name]; ok {
+		return fmt.Errorf("%w: %s is already registered", ErrInvalidPragma, name)
+	}
+	pragmaRegistry.handlers[name] = handler
+	return nil
+}
+
+// The UnregisterPragma function removes a pragma added with RegisterPragma. Once removed,
+// the pragma is unknown, and is reported as a *ParseError like any other unknown pragma.
+func UnregisterPragma(name string) {
+	pragmaRegistry.Lock()
+	defer pragmaRegistry.Unlock()
+	delete(pragmaRegistry.handlers, name)
+}
+
+// Determine whether the key is a built-in pragma or one added with RegisterPragma.
+func isKnownPragma(key string) bool {
+	for _, builtin := range builtinPragmas {
+		if key == builtin {
+			return true
+		}
+	}
+	_, ok := lookupPragma(key)
+	return ok
+}
+
+// Find the handler registered for the given key.
+func lookupPragma(key string) (PragmaHandler, bool) {
+	pragmaRegistry.RLock()
+	defer pragmaRegistry.RUnlock()
+	handler, ok := pragmaRegistry.handlers[key]
+	return handler, ok
+}
+
+// The PragmaContext type describes a registered pragma, as it is passed to its handler,
+// and gives the handler access to the read that it is a part of.
+type PragmaContext struct {
+	Name      string     // the pragma, such as "!require-version"
+	SrcFile   string     // the file containing the pragma
+	SrcLine   int        // the 1-based line number of the pragma
+	SrcOrigin FileOrigin // the type of file containing the pragma
+	ctx       *readContext
+}
+
+// The Variable method returns the value of a variable passed with the WithVariables option.
+func (pragma *PragmaContext) Variable(name string) (string, bool) {
+	value, ok := pragma.ctx.variables[name]
+	return value, ok
+}
+
+// The NewItem method creates a key/value item whose source is the pragma's file and line,
+// so that WriteInternal shows where an item added by the handler came from.
+func (pragma *PragmaContext) NewItem(key string, value string) Item {
+	item := NewItem(key, value)
+	item.srcFile = pragma.SrcFile
+	item.srcLine = pragma.SrcLine
+	item.srcOrigin = pragma.SrcOrigin
+	return item
+}
+
+// The SetMetadata method records a name/value pair for the tree being read. Once the read
+// has finished, it is available from the Metadata method of the root branch.
+func (pragma *PragmaContext) SetMetadata(name string, value string) {
+	if pragma.ctx.metadata == nil {
+		pragma.ctx.metadata = make(map[string]string)
+	}
+	pragma.ctx.metadata[name] = value
+}
+
+// The ReadFile method reads a file for the handler, in the same way as a !include pragma:
+// a relative filename is resolved against the directory of the pragma's file, and the file
+// is read from the same file system, subject to the same limits on its size.
+//
+// Returns a *LimitError when the WithoutFilePragmas option is in effect.
+func (pragma *PragmaContext) ReadFile(localFilename string) ([]byte, error) {
+	ctx := pragma.ctx
+	if ctx.noFilePragmas {
+		return nil, &LimitError{Limit: LimitFilePragmas, SrcFile: pragma.SrcFile, SrcLine: pragma.SrcLine, Pragma: pragma.Name}
+	}
+	filename, err := ctx.resolveFilename(pragma.SrcFile, localFilename)
+	if err != nil {
+		return nil, err
+	}
+	var file io.ReadCloser
+	if ctx.fsys != nil {
+		file, err = ctx.fsys.Open(filename)
+	} else {
+		file, err = os.Open(filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if ctx.maxFileSize > 0 {
+		r = &sizeLimitedReader{
+			r:         r,
+			remaining: ctx.maxFileSize,
+			err:       &LimitError{Limit: LimitFileSize, Max: ctx.maxFileSize, SrcFile: filename},
+		}
+	}
+	return io.ReadAll(r)
+}
+
+// The PragmaError type is returned when the handler of a registered pragma fails.
+// The Err field is the handler's error, and is matched by errors.Is and errors.As.
+type PragmaError struct {
+	Pragma  string // the pragma, such as "!require-version"
+	SrcFile string // the file containing the pragma
+	SrcLine int    // the 1-based line number of the pragma
+	Err     error
+}
+
+// Formats the error as "srcFile:srcLine: pragma: " followed by the handler's error.
+func (e *PragmaError) Error() string {
+	return fmt.Sprintf("%s:%d: %s: %v", e.SrcFile, e.SrcLine, e.Pragma, e.Err)
+}
+
+// Returns the handler's error.
+func (e *PragmaError) Unwrap() error {
+	return e.Err
+}
+
+// Call the handler of a registered pragma, which has already been appended to the branch.
+func (branch *Branch) readRegisteredPragma(ctx *readContext, handler PragmaHandler, key string, value string, srcFile string, srcLine int, srcOrigin FileOrigin) error {
+	pragma := PragmaContext{
+		Name:      key,
+		SrcFile:   srcFile,
+		SrcLine:   srcLine,
+		SrcOrigin: srcOrigin,
+		ctx:       ctx,
+	}
+	err := handler(branch, value, &pragma)
+	if err != nil {
+		return &PragmaError{Pragma: key, SrcFile: srcFile, SrcLine: srcLine, Err: err}
+	}
+	return nil
+}
+
+// The Metadata method returns a value recorded by the handler of a registered pragma
+// during the read that created this root branch.
+func (branch *Branch) Metadata(name string) (string, bool) {
+	value, ok := branch.metadata[name]
+	return value, ok
+}
@@ -0,0 +1,152 @@
+//=============================================================================
+// File:     pragma_test.go
+// Tests:    A registered pragma records metadata or fails the parse
+//           A registered pragma reads a file and inserts an item
+//           RegisterPragma refuses invalid and built-in names
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/readwritepro/figtree"
+)
+
+// A handler for the !require-version pragma, which fails unless the "version" variable is at least the value.
+func requireVersion(branch *figtree.Branch, value string, pragma *figtree.PragmaContext) error {
+	version, _ := pragma.Variable("version")
+	if version < value {
+		return fmt.Errorf("version %s is required, but this is version %s", value, version)
+	}
+	pragma.SetMetadata("required-version", value)
+	return nil
+}
+
+// A handler for the !secret-file pragma, whose value is a key followed by a filename,
+// which adds an item holding the contents of the file.
+func secretFile(branch *figtree.Branch, value string, pragma *figtree.PragmaContext) error {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return errors.New("a key and a filename are required")
+	}
+	contents, err := pragma.ReadFile(fields[1])
+	if err != nil {
+		return err
+	}
+	branch.AppendItem(pragma.NewItem(fields[0], strings.TrimSpace(string(contents))))
+	return nil
+}
+
+func TestRegisteredPragma(t *testing.T) {
+	if err := figtree.RegisterPragma("!require-version", requireVersion); err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	defer figtree.UnregisterPragma("!require-version")
+
+	input := "!require-version 2.3\nname app\n"
+	root, err := figtree.ParseString(input, figtree.WithVariables(map[string]string{"version": "2.4"}))
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	actual, ok := root.Metadata("required-version")
+	if !ok || actual != "2.3" {
+		t.Errorf("expected '2.3', got '%s'", actual)
+	}
+
+	// the pragma is kept in the tree, so that it is written back out
+	wf := figtree.WriteFigtree{}
+	buf, _ := root.WriteToBuffer(wf)
+	if input != buf {
+		t.Errorf("expected\n%s\ngot\n%s", input, buf)
+	}
+
+	_, err = figtree.ParseString(input, figtree.WithVariables(map[string]string{"version": "2.2"}))
+	var pragmaErr *figtree.PragmaError
+	expected := "string:1: !require-version: version 2.3 is required, but this is version 2.2"
+	if !errors.As(err, &pragmaErr) || pragmaErr.SrcLine != 1 || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+
+	// a tolerant read collects the error and carries on
+	root, diagnostics := figtree.ReadFigtreeFromTolerant(strings.NewReader(input), "string")
+	if len(diagnostics) != 1 || diagnostics[0].SrcLine != 1 {
+		t.Errorf("expected one diagnostic on line 1, got '%v'", diagnostics)
+	}
+	if actual, _ := root.GetValue("name"); actual != "app" {
+		t.Errorf("expected 'app', got '%s'", actual)
+	}
+
+	// once unregistered, the pragma is unknown, and a tolerant read keeps it as an ordinary key/value pair
+	figtree.UnregisterPragma("!require-version")
+	_, err = figtree.ParseString(input)
+	var parseErr *figtree.ParseError
+	if !errors.As(err, &parseErr) || parseErr.Reason != figtree.ReasonUnknownPragma {
+		t.Errorf("expected '%v', got '%v'", figtree.ReasonUnknownPragma, err)
+	}
+	root, _ = figtree.ReadFigtreeFromTolerant(strings.NewReader(input), "string")
+	if actual, _ := root.GetValue("!require-version"); actual != "2.3" {
+		t.Errorf("expected '2.3', got '%s'", actual)
+	}
+}
+
+func TestRegisteredPragmaReadFile(t *testing.T) {
+	if err := figtree.RegisterPragma("!secret-file", secretFile); err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	defer figtree.UnregisterPragma("!secret-file")
+
+	fsys := fstest.MapFS{
+		"conf/app.fig":      {Data: []byte("database {\n\t!secret-file password db.secret\n}\n")},
+		"conf/db.secret":    {Data: []byte("hunter2\n")},
+		"conf/missing.fig":  {Data: []byte("!secret-file password nonexistent\n")},
+		"conf/too-many.fig": {Data: []byte("!secret-file password\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "conf/app.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	item, err := root.GetItem("database/password")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	if actual, _ := item.Value(); actual != "hunter2" {
+		t.Errorf("expected 'hunter2', got '%s'", actual)
+	}
+
+	for _, name := range []string{"conf/missing.fig", "conf/too-many.fig"} {
+		_, err = figtree.ReadConfigFS(fsys, name)
+		var pragmaErr *figtree.PragmaError
+		if !errors.As(err, &pragmaErr) || pragmaErr.Pragma != "!secret-file" {
+			t.Errorf("%s: expected a *PragmaError, got '%v'", name, err)
+		}
+	}
+
+	// a handler can't read files when file pragmas are refused, even in a tolerant read
+	_, err = figtree.ReadConfigFS(fsys, "conf/app.fig", figtree.WithoutFilePragmas())
+	if !errors.Is(err, figtree.ErrLimitExceeded) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrLimitExceeded, err)
+	}
+}
+
+func TestRegisterPragmaNames(t *testing.T) {
+	for _, name := range []string{"require-version", "!", "!two words", "!include", "!if"} {
+		err := figtree.RegisterPragma(name, requireVersion)
+		if !errors.Is(err, figtree.ErrInvalidPragma) {
+			t.Errorf("%s: expected '%v', got '%v'", name, figtree.ErrInvalidPragma, err)
+		}
+	}
+
+	if err := figtree.RegisterPragma("!require-version", requireVersion); err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	defer figtree.UnregisterPragma("!require-version")
+	err := figtree.RegisterPragma("!require-version", requireVersion)
+	if !errors.Is(err, figtree.ErrInvalidPragma) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrInvalidPragma, err)
+	}
+}
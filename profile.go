@@ -0,0 +1,139 @@
+//=============================================================================
+// File:     profile.go
+// Contents: readProfile handles profile blocks
+//           applyProfiles merges the selected profiles over their enclosing branches
+//           isProfileBlock
+//=============================================================================
+
+package figtree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The key of a profile block is this prefix followed by the profile's name.
+const profilePrefix = "profile "
+
+// Determine whether a key and value begin a profile block, such as "profile staging {".
+//
+// Returns the profile's name, which is a single word without any slashes.
+func parseProfileOperator(key string, value string) (string, bool) {
+	if key != "profile" || !strings.HasSuffix(value, "{") {
+		return "", false
+	}
+	name := strings.Trim(strings.TrimSuffix(value, "{"), " \t")
+	if name == "" || strings.ContainsAny(name, " \t/") {
+		return "", false
+	}
+	return name, true
+}
+
+// Special processing for a profile block. The block is kept in the tree as a branch item whose
+// key is "profile" followed by the profile's name, and every item within it records the name,
+// so that WriteInternal can show which profile supplied a value once it has been merged.
+//
+// The normal return is the sentinal ErrEndOfBranch, as with handleBranch.
+func (branch *Branch) readProfile(ctx *readContext, parser *EventParser, event Event, blockComments []string, srcOrigin FileOrigin) error {
+	name := event.Value
+	first := len(branch.Items)
+	err := branch.handleBranch(ctx, parser, event, profilePrefix+name, blockComments, srcOrigin)
+	if innerBranch, ok := branch.Items[first].value.(*Branch); ok {
+		innerBranch.setProfile(name)
+	}
+	return err
+}
+
+// Record the profile name on every item of the branch and its inner branches.
+func (branch *Branch) setProfile(name string) {
+	for i := range branch.Items {
+		branch.Items[i].profile = name
+		if innerBranch, ok := branch.Items[i].value.(*Branch); ok {
+			innerBranch.setProfile(name)
+		}
+	}
+}
+
+// Merge each of the selected profiles, in order, over the branch that encloses it,
+// which is the root for a profile block that is not nested within another branch.
+// Later profiles take precedence over earlier ones.
+//
+// Returns ErrProfileNotFound when a selected profile does not appear anywhere in the tree.
+func (ctx *readContext) applyProfiles(root *Branch) error {
+	if len(ctx.profiles) == 0 {
+		return nil
+	}
+	found := make(map[string]bool)
+	root.applyProfiles(ctx.profiles, found)
+	for _, name := range ctx.profiles {
+		if !found[name] {
+			return fmt.Errorf("%w: %s", ErrProfileNotFound, name)
+		}
+	}
+	return nil
+}
+
+// Recursive implementation of applyProfiles. Inner branches are handled first,
+// and the profile blocks themselves are left unchanged.
+func (branch *Branch) applyProfiles(names []string, found map[string]bool) {
+	for i := range branch.Items {
+		item := &branch.Items[i]
+		if innerBranch, ok := item.value.(*Branch); ok && !isProfileBlock(*item) {
+			innerBranch.applyProfiles(names, found)
+		}
+	}
+	for _, name := range names {
+		profileBranch, err := branch.GetBranch(profilePrefix + name)
+		if err != nil {
+			continue
+		}
+		found[name] = true
+		// merge a deep copy, so that later profiles can't alter the profile block's own branches
+		branch.Merge(profileBranch.deepCopy())
+	}
+}
+
+// Determine whether the item is a profile block.
+func isProfileBlock(item Item) bool {
+	_, isBranch := item.value.(*Branch)
+	return isBranch && strings.HasPrefix(item.key, profilePrefix)
+}
+
+// Replace each item that a selected profile supplied by the items it replaced, if any,
+// so that the items are written as they were before the profile was merged.
+func unprofiledItems(items []Item) []Item {
+	result := make([]Item, 0, len(items))
+	for _, item := range items {
+		if item.profile == "" {
+			result = append(result, item)
+		} else {
+			result = append(result, unprofiledItems(item.overridden)...)
+		}
+	}
+	return result
+}
+
+// Make a copy of the branch and all of its inner branches.
+func (branch *Branch) deepCopy() *Branch {
+	return branch.deepCopyShared(make(map[*Branch]*Branch))
+}
+
+// Make a deep copy of the branch, in which a branch shared by several items, such as one
+// copied from a taken conditional block, is copied only once and remains shared.
+func (branch *Branch) deepCopyShared(copies map[*Branch]*Branch) *Branch {
+	if newBranch, ok := copies[branch]; ok {
+		return newBranch
+	}
+	newBranch := branch.Copy()
+	copies[branch] = newBranch
+	for i := range newBranch.Items {
+		item := &newBranch.Items[i]
+		if innerBranch, ok := item.value.(*Branch); ok {
+			item.value = innerBranch.deepCopyShared(copies)
+		}
+		if item.conditional != nil {
+			item.conditional = item.conditional.deepCopyShared(copies)
+		}
+	}
+	return newBranch
+}
@@ -0,0 +1,144 @@
+//=============================================================================
+// File:     profile_test.go
+// Tests:    Profiles selected with WithProfiles stack in order
+//           Profiles nested within a branch
+//           WriteInternal shows the profile that supplied each value
+//           WriteFigtree reproduces the profile blocks
+//           WriteFigtree writes the values replaced by a selected profile
+//           WriteJson and WriteYaml write the selected profile's values without the blocks
+//           An unknown profile
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/readwritepro/figtree"
+)
+
+const profileInput = `host localhost
+port 8080
+database {
+	name app
+	profile eu-west {
+		region eu
+	}
+}
+profile staging {
+	host staging.example.com
+	database {
+		name app-staging
+	}
+}
+profile eu-west {
+	host eu.example.com
+}
+`
+
+func TestProfiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.fig": {Data: []byte(profileInput)},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "app.fig", figtree.WithProfiles("staging", "eu-west"))
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	// the later profile takes precedence, and nested profiles are merged over their own branch
+	tests := map[string]string{
+		"host":            "eu.example.com",
+		"port":            "8080",
+		"database/name":   "app-staging",
+		"database/region": "eu",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", keyPath, expected, actual, err)
+		}
+	}
+
+	// the profile blocks themselves are unchanged
+	actual, _ := root.GetValue("profile staging/host")
+	expected := "staging.example.com"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+
+	wi := figtree.WriteInternal{}
+	buf, _ := root.WriteToBuffer(wi)
+	expected = "(User:eu-west)[app.fig:16]       host eu.example.com\n"
+	if !strings.HasPrefix(buf, expected) {
+		t.Errorf("expected the first line to be\n%s\ngot\n%s", expected, buf)
+	}
+}
+
+func TestProfilesWriteFigtree(t *testing.T) {
+	root, err := figtree.ParseString(profileInput)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	actual, _ := root.GetValue("host")
+	if actual != "localhost" {
+		t.Errorf("expected 'localhost', got '%s'", actual)
+	}
+
+	wf := figtree.WriteFigtree{}
+	buf, _ := root.WriteToBuffer(wf)
+	if profileInput != buf {
+		t.Errorf("expected\n%s\ngot\n%s", profileInput, buf)
+	}
+}
+
+func TestProfilesWriteSelected(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.fig": {Data: []byte(profileInput + "profile empty {\n\t!unset port\n\t!unset database/name\n}\n")},
+	}
+	profiles := figtree.WithProfiles("staging", "eu-west", "empty")
+	root, err := figtree.ReadConfigFS(fsys, "app.fig", profiles)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	if root.ItemExists("port") || root.PathExists("database/name") {
+		t.Errorf("expected 'port' and 'database/name' to be removed")
+	}
+
+	// the file reads back the same, with or without the profiles
+	wf := figtree.WriteFigtree{}
+	buf, _ := root.WriteToBuffer(wf)
+	expected := profileInput + "profile empty {\n\t!unset port\n\t!unset database/name\n}\n"
+	if expected != buf {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf)
+	}
+	root, err = figtree.ParseString(buf)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	if actual, _ := root.GetValue("host"); actual != "localhost" {
+		t.Errorf("expected 'localhost', got '%s'", actual)
+	}
+
+	root, _ = figtree.ParseString(profileInput, figtree.WithProfiles("staging"))
+	wj := figtree.WriteJson{}
+	buf, _ = root.WriteToBuffer(wj)
+	if strings.Contains(buf, "profile") || !strings.Contains(buf, `"host": "staging.example.com"`) {
+		t.Errorf("expected only the staging values, got\n%s", buf)
+	}
+	wy := figtree.WriteYaml{}
+	buf, _ = root.WriteToBuffer(wy)
+	if strings.Contains(buf, "profile") || !strings.Contains(buf, "host: staging.example.com") {
+		t.Errorf("expected only the staging values, got\n%s", buf)
+	}
+}
+
+func TestUnknownProfile(t *testing.T) {
+	_, err := figtree.ParseString(profileInput, figtree.WithProfiles("production"))
+	if !errors.Is(err, figtree.ErrProfileNotFound) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrProfileNotFound, err)
+	}
+}
@@ -0,0 +1,129 @@
+//=============================================================================
+// File:     quote.go
+// Contents: Quoted-value syntax
+//           valueNeedsQuotes, containsControlCharacter, quoteValue, unquoteValue
+//=============================================================================
+
+package figtree
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Determines whether a value would be read back differently if it were written
+// without quotation marks. This is the case when it has leading or trailing whitespace,
+// contains a line break or other control character, contains something that would be
+// parsed as a terminal comment, begins with a quotation mark, or is a lone opening brace
+// or heredoc operator.
+func valueNeedsQuotes(value string) bool {
+	if value == "" {
+		return false
+	}
+	if value == "{" || value[0] == '"' || value[0] == '#' {
+		return true
+	}
+	if _, _, ok := parseHeredocOperator(value); ok {
+		return true
+	}
+	if strings.Trim(value, " \t") != value {
+		return true
+	}
+	if strings.Contains(value, " #") || strings.Contains(value, "\t#") {
+		return true
+	}
+	return containsControlCharacter(value)
+}
+
+// Determines whether a value contains a line break or other control character,
+// which can't be shown verbatim on a single line.
+func containsControlCharacter(value string) bool {
+	for _, r := range value {
+		if r < 0x20 || r == 0x7F {
+			return true
+		}
+	}
+	return false
+}
+
+// Delimit the value with quotation marks, escaping any character that cannot be
+// written verbatim within the quotes.
+func quoteValue(value string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7F {
+				fmt.Fprintf(&sb, `\u%04X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// Parse a quoted value from the beginning of the given text, which must begin with a quotation mark.
+// The recognized escape sequences are \" \\ \n \r \t and \uXXXX.
+//
+// Returns the unescaped value and the remainder of the text following the closing quotation mark.
+// Returns a reason code and the offending text when the value is malformed.
+func unquoteValue(text string) (value string, remainder string, reason ParseReason, offending string, ok bool) {
+	var sb strings.Builder
+	for i := 1; i < len(text); {
+		c := text[i]
+		switch c {
+		case '"':
+			return sb.String(), text[i+1:], 0, "", true
+		case '\\':
+			if i+1 >= len(text) {
+				return "", "", ReasonUnterminatedQuote, text[:1], false
+			}
+			switch text[i+1] {
+			case '"':
+				sb.WriteByte('"')
+			case '\\':
+				sb.WriteByte('\\')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'u':
+				if i+6 > len(text) {
+					return "", "", ReasonInvalidEscape, text[i:], false
+				}
+				code, err := strconv.ParseUint(text[i+2:i+6], 16, 32)
+				if err != nil {
+					return "", "", ReasonInvalidEscape, text[i : i+6], false
+				}
+				sb.WriteRune(rune(code))
+				i += 6
+				continue
+			default:
+				return "", "", ReasonInvalidEscape, text[i : i+2], false
+			}
+			i += 2
+		default:
+			_, size := utf8.DecodeRuneInString(text[i:])
+			sb.WriteString(text[i : i+size])
+			i += size
+		}
+	}
+	return "", "", ReasonUnterminatedQuote, text[:1], false
+}
@@ -0,0 +1,127 @@
+//=============================================================================
+// File:     quote_test.go
+// Tests:    Read quoted values with escape sequences
+//           Malformed quoted values
+//           Quotation marks are part of the value without WithQuotedValues
+//           WriteFigtree round-trip of values that need quotes
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/readwritepro/figtree"
+)
+
+func TestReadQuotedValues(t *testing.T) {
+	input := `key1 "  leading and trailing  "    # comment
+key2 "a # not a comment"
+key3 "line one\nline two\ttabbed \"quoted\" \\ é"
+key4 "{"
+key5 ""
+`
+	root, err := figtree.ParseString(input, figtree.WithQuotedValues())
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	tests := map[string]string{
+		"key1": "  leading and trailing  ",
+		"key2": "a # not a comment",
+		"key3": "line one\nline two\ttabbed \"quoted\" \\ é",
+		"key4": "{",
+		"key5": "",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%q', got '%q' (%v)", keyPath, expected, actual, err)
+		}
+	}
+}
+
+func TestReadMalformedQuotedValues(t *testing.T) {
+	tests := map[string]string{
+		"key \"unterminated\n":     "string:1:5: unterminated quoted value",
+		"key \"bad \\q escape\"\n": "string:1:10: invalid escape sequence",
+		"key \"value\" trailing\n": "string:1:13: unexpected text after quoted value",
+	}
+	for input, expected := range tests {
+		_, err := figtree.ParseString(input, figtree.WithQuotedValues())
+		if !errors.Is(err, figtree.ErrSyntax) {
+			t.Errorf("expected '%v', got '%v'", figtree.ErrSyntax, err)
+		}
+		if err == nil || expected != err.Error() {
+			t.Errorf("expected '%s', got '%v'", expected, err)
+		}
+	}
+}
+
+func TestReadWithoutQuotedValues(t *testing.T) {
+	input := "key1 \"double quotes\"\nkey2 \"a\" b\nkey3 \"unterminated\n"
+	root, err := figtree.ParseString(input)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	tests := map[string]string{
+		"key1": "\"double quotes\"",
+		"key2": "\"a\" b",
+		"key3": "\"unterminated",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%q', got '%q' (%v)", keyPath, expected, actual, err)
+		}
+	}
+
+	// the values are written back out as they were read
+	wf := figtree.WriteFigtree{}
+	buf, _ := root.WriteToBuffer(wf)
+	if input != buf {
+		t.Errorf("expected\n%s\ngot\n%s", input, buf)
+	}
+}
+
+func TestWriteQuotedValues(t *testing.T) {
+	values := []string{
+		"plain value",
+		"  leading",
+		"trailing\t",
+		"a # b",
+		"#hashtag",
+		"\"quoted\"",
+		"multi\nline\r\n",
+		"{",
+		"control \x01 character",
+	}
+
+	root := figtree.NewBranch()
+	for _, value := range values {
+		root.AppendItem(figtree.NewItem("key", value))
+	}
+
+	wf := figtree.WriteFigtree{QuotedValues: true}
+	buf, err := root.WriteToBuffer(wf)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	reread, err := figtree.ParseString(buf, figtree.WithQuotedValues())
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'\n%s", err, buf)
+	}
+	items := reread.QueryAll("key")
+	if len(items) != len(values) {
+		t.Fatalf("expected %d items, got %d\n%s", len(values), len(items), buf)
+	}
+	for i, item := range items {
+		actual, _ := item.Value()
+		if values[i] != actual {
+			t.Errorf("expected '%q', got '%q'", values[i], actual)
+		}
+	}
+}
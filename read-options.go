@@ -0,0 +1,141 @@
+//=============================================================================
+// File:     read-options.go
+// Contents: ReadOption type declaration
+//           Options accepted by ReadConfig and the other read functions
+//=============================================================================
+
+package figtree
+
+// The ReadOption type configures a single call to ReadConfig, ReadConfigFS,
+// ReadFigtree, ReadFigtreeFrom, or one of their tolerant variants.
+type ReadOption func(ctx *readContext)
+
+// The default limit on how deeply !include, !baseline, and !dtd pragmas may be nested.
+const defaultMaxIncludeDepth = 32
+
+// Create the read context for a single read, applying each of the options in order.
+func newReadContext(options []ReadOption) *readContext {
+	ctx := &readContext{
+		maxIncludeDepth: defaultMaxIncludeDepth,
+		maxDepth:        defaultMaxDepth,
+		maxLineLength:   defaultMaxLineLength,
+		lookupEnv:       lookupEnv,
+	}
+	for _, option := range options {
+		option(ctx)
+	}
+	return ctx
+}
+
+// The WithWorkingDirPaths option restores the original behavior of resolving relative
+// !include, !baseline, and !dtd filenames against the current working directory
+// (or the root of the fs.FS), rather than against the directory of the file containing the pragma.
+func WithWorkingDirPaths() ReadOption {
+	return func(ctx *readContext) {
+		ctx.workingDirPaths = true
+	}
+}
+
+// The WithMaxIncludeDepth option limits how deeply !include, !baseline, and !dtd pragmas
+// may be nested. A file referenced directly by the user's file is at depth 1.
+// Exceeding the limit returns an *IncludeError matching ErrIncludeDepth.
+// The default is 32.
+func WithMaxIncludeDepth(maxDepth int) ReadOption {
+	return func(ctx *readContext) {
+		ctx.maxIncludeDepth = maxDepth
+	}
+}
+
+// The WithEnvExpansion option enables the expansion of ${NAME} references to
+// environment variables, and of ${key/path} references to other items, in leaf values,
+// with $$ being a literal dollar sign. Without it, leaf values are kept exactly as written.
+func WithEnvExpansion() ReadOption {
+	return func(ctx *readContext) {
+		ctx.expandEnv = true
+	}
+}
+
+// The WithVariables option supplies the variables that the conditions of !if pragmas are
+// evaluated against, such as {"env": "production"}. A variable that is not supplied has
+// the value of an empty string.
+func WithVariables(variables map[string]string) ReadOption {
+	return func(ctx *readContext) {
+		ctx.variables = variables
+	}
+}
+
+// The WithProfiles option selects the profile blocks, such as "profile staging { ... }", whose
+// items are merged over the branch enclosing them, using the same rules as Branch.Merge.
+// Profiles are merged in the order given, so a later profile takes precedence over an earlier one.
+// A selected profile that does not appear in the tree is reported as ErrProfileNotFound.
+func WithProfiles(names ...string) ReadOption {
+	return func(ctx *readContext) {
+		ctx.profiles = append(ctx.profiles, names...)
+	}
+}
+
+// The WithMaxFileSize option limits the number of bytes in each file that is read,
+// including files referenced by pragmas. Exceeding the limit returns a *LimitError.
+func WithMaxFileSize(maxBytes int64) ReadOption {
+	return func(ctx *readContext) {
+		ctx.maxFileSize = maxBytes
+	}
+}
+
+// The WithMaxLineLength option limits the number of bytes in each line, not counting
+// its line ending. Exceeding the limit returns a *LimitError.
+// The default is 16 MiB, and zero removes the limit.
+func WithMaxLineLength(maxBytes int) ReadOption {
+	return func(ctx *readContext) {
+		ctx.maxLineLength = maxBytes
+	}
+}
+
+// The WithMaxDepth option limits how deeply branches may be nested within a file.
+// A branch at the root of the file is at depth 1. Exceeding the limit returns a *LimitError.
+// The default is 1000, and zero removes the limit.
+func WithMaxDepth(maxDepth int) ReadOption {
+	return func(ctx *readContext) {
+		ctx.maxDepth = maxDepth
+	}
+}
+
+// The WithMaxItems option limits the total number of key/value pairs and branches
+// read from all files. Exceeding the limit returns a *LimitError.
+func WithMaxItems(maxItems int) ReadOption {
+	return func(ctx *readContext) {
+		ctx.maxItems = maxItems
+	}
+}
+
+// The WithoutFilePragmas option refuses every pragma that reads another file,
+// namely !include and its variants, !baseline, and !dtd, returning a *LimitError instead.
+// The handlers of registered pragmas are likewise refused when they call PragmaContext.ReadFile.
+// It is intended for configurations supplied by untrusted users, which should also be read
+// without the WithEnvExpansion option, since it would reveal the process's environment variables.
+func WithoutFilePragmas() ReadOption {
+	return func(ctx *readContext) {
+		ctx.noFilePragmas = true
+	}
+}
+
+// The WithQuotedValues option reads a value that begins with a quotation mark as a quoted value,
+// which is delimited by a closing quotation mark and may contain escape sequences, as described
+// in the package documentation. Without it, quotation marks are an ordinary part of the value.
+// Files written by WriteFigtree with QuotedValues set to true should be read with this option.
+func WithQuotedValues() ReadOption {
+	return func(ctx *readContext) {
+		ctx.quotedValues = true
+	}
+}
+
+// The WithStrict option rejects keys that are probably mistakes, returning a *ParseError for
+// an empty key, a key that could not be written back out, such as one containing a solidus, and
+// a key that appears more than once within a branch, unless it ends in [], or is declared as an
+// array with the !array pragma.
+// A tolerant read reports each of these, and keeps the item.
+func WithStrict() ReadOption {
+	return func(ctx *readContext) {
+		ctx.strict = true
+	}
+}
@@ -1,45 +1,121 @@
 //=============================================================================
-// File:     reader.go
+// File:     read.go
 // Contents: ReadConfig scans a user config file, and merges it with any baseline
 //            file referenced by a !baseline pragma.
+//           ReadConfigFS reads a user config file from an fs.FS.
 //           ReadFigtree scans a file that contains figtree syntax, and any files
 //            embedded via an include pragma.
-//           ParseBranch recursively scans figtree syntax creating an in-memory
-//            tree of branches and items.
+//           ReadFigtreeFrom and ParseString scan figtree syntax from an io.Reader
+//            or a string.
+//           ParseBranch recursively builds an in-memory tree of branches and items
+//            from the events of an EventParser.
 //=============================================================================
 
 package figtree
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	eh "github.com/readwritepro/error-handler"
 )
 
+// The readContext type holds the state shared by every file that is parsed during a
+// single read, including the file system that pragma filenames are resolved against.
+type readContext struct {
+	fsys            fs.FS // nil when reading from the operating system's file system
+	workingDirPaths bool  // when true, relative pragma filenames are resolved against the working directory
+	maxIncludeDepth int   // the limit on the length of includeChain
+	expandEnv       bool  // when true, ${NAME} references in leaf values are expanded
+	lookupEnv       func(string) (string, bool)
+	variables       map[string]string // the variables that !if conditions are evaluated against
+	profiles        []string          // the names of the profiles to merge, in order of increasing precedence
+	maxFileSize     int64             // the limit on the number of bytes in each file, or zero for no limit
+	maxLineLength   int               // the limit on the number of bytes in each line, or zero for no limit
+	maxDepth        int               // the limit on how deeply branches may be nested, or zero for no limit
+	maxItems        int               // the limit on the number of items in all files, or zero for no limit
+	noFilePragmas   bool              // when true, the !include, !baseline, and !dtd pragmas are refused
+	strict          bool              // when true, unknown pragmas and questionable keys are errors
+	quotedValues    bool              // when true, a value that begins with a quotation mark is a quoted value
+	tolerant        bool              // when true, problems are collected as diagnostics rather than halting the read
+	diagnostics     []Diagnostic      // the problems collected by a tolerant read
+
+	openFiles    []string          // the files currently being parsed, outermost first, used to detect include cycles
+	includeChain []IncludeLink     // the pragmas that led to the file currently being parsed
+	untakenDepth int               // the number of enclosing conditional blocks that are not taken
+	baselineTree *Branch           // the tree of items built by the !baseline pragma, if any
+	dtdTree      *Branch           // the tree of items built by the !dtd pragma, if any
+	itemCount    int               // the number of items read so far, in all files
+	metadata     map[string]string // the values recorded by the handlers of registered pragmas
+}
+
 // The ReadConfig function reads a user's configuration file into memory, honoring any baseline pragma it may contain.
 //
 // Returns the root branch of the tree created by merging the user's file with any baseline file it may point to.
 //
-// May return ErrEndOfBranch if the parser prematurely stopped, before parsing
-// the entire file, due to a misconfigured closing brace.
-func ReadConfig(inFilename string) (*Branch, error) {
+// Returns a *ParseError if the file, or any file it references, contains a syntax error,
+// and a *ReferenceError if a ${key/path} reference can't be resolved.
+// A misconfigured closing brace is reported with ReasonUnexpectedClosingBrace, and
+// matches ErrEndOfBranch when tested with errors.Is.
+func ReadConfig(inFilename string, options ...ReadOption) (*Branch, error) {
+	return NewReader(options...).ReadConfig(inFilename)
+}
 
-	gBaselineTree = nil // reset the global baselineTree
+// The ReadConfigFS function is identical to ReadConfig, except that the user's
+// configuration file, and every file referenced by an !include, !baseline, or !dtd
+// pragma, is opened from the given file system rather than from the operating system.
+// This allows configurations to be embedded in the executable using go:embed.
+//
+// Filenames must follow the fs.FS conventions: they are unrooted and slash-separated.
+// A leading slash on a pragma filename is ignored.
+func ReadConfigFS(fsys fs.FS, name string, options ...ReadOption) (*Branch, error) {
+	return NewReader(options...).ReadConfigFS(fsys, name)
+}
 
-	userTree, err := ReadFigtree(inFilename, UserFile)
+// Read the user's file, merge it with its baseline, if any, and finish the merged tree.
+func (ctx *readContext) readConfig(inFilename string) (*Branch, error) {
+	mergedBranch, err := ctx.mergeConfig(inFilename)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.finishTree(mergedBranch); err != nil {
+		return nil, err
+	}
+	return mergedBranch, nil
+}
+
+// Read the user's file and merge it with its baseline, if any.
+func (ctx *readContext) mergeConfig(inFilename string) (*Branch, error) {
+	userTree, err := ctx.readFigtree(inFilename, UserFile)
 	if err != nil {
 		return nil, err
 	}
 
 	// Reading the user's file may have triggered the creation of a baseline tree via the !baseline pragma
 	// Now that the user's tree and the baseline tree are both fully parsed and in memory, merge them.
-	mergedBranch := mergeBaselineWithUser(gBaselineTree, userTree)
+	mergedBranch := mergeBaselineWithUser(ctx.baselineTree, userTree)
+	mergedBranch.lineEnding = userTree.lineEnding
 	return mergedBranch, nil
 }
 
+// Merge any selected profiles over the fully read tree, then resolve the references between its items,
+// so that references may refer to items from the baseline, the user's file, or a profile.
+func (ctx *readContext) finishTree(root *Branch) error {
+	if err := ctx.applyProfiles(root); err != nil {
+		return err
+	}
+	root.metadata = ctx.metadata
+	return root.resolveReferences(ctx)
+}
+
 // The ReadFigtree function opens, parses, and closes the given file.
 // This is a public function, and may be called to read any file containing
 // figtree syntax, but it is rarely used publicly. See the ReadConfig function for that.
@@ -47,206 +123,612 @@ func ReadConfig(inFilename string) (*Branch, error) {
 // The returned Branch is the root of the configuration tree which is used
 // in subsequent calls to access and alter the tree's inner branches and items.
 //
-// Returns ErrEndOfBranch if the parser prematurely stopped, before parsing
-// the entire file, due to a misconfigured closing brace.
-func ReadFigtree(inFilename string, fileOrigin FileOrigin) (*Branch, error) {
-	inFile, err := os.Open(inFilename)
+// Returns a *ParseError if the file, or any file it includes, contains a syntax error.
+func ReadFigtree(inFilename string, fileOrigin FileOrigin, options ...ReadOption) (*Branch, error) {
+	return NewReader(options...).ReadFigtree(inFilename, fileOrigin)
+}
+
+// The ReadFigtreeFrom function parses figtree syntax from the given reader.
+// The srcFile argument is the name recorded as the source of each item; it is
+// not opened, but relative pragma filenames are resolved against its directory.
+// Any !include, !baseline, or !dtd pragmas are read from the operating system's file system.
+//
+// Returns a *ParseError if the input, or any file it includes, contains a syntax error.
+func ReadFigtreeFrom(r io.Reader, srcFile string, options ...ReadOption) (*Branch, error) {
+	return NewReader(options...).ReadFigtreeFrom(r, srcFile)
+}
+
+// The ParseString function parses a string containing figtree syntax.
+// It is a convenience for building small trees in tests and examples.
+//
+// Returns a *ParseError if the string contains a syntax error.
+func ParseString(figtreeSyntax string, options ...ReadOption) (*Branch, error) {
+	return NewReader(options...).ParseString(figtreeSyntax)
+}
+
+// Open the given file, from the context's file system when it has one, then parse it.
+func (ctx *readContext) readFigtree(inFilename string, fileOrigin FileOrigin) (*Branch, error) {
+	var inFile io.ReadCloser
+	var err error
+	if ctx.fsys != nil {
+		inFile, err = ctx.fsys.Open(inFilename)
+	} else {
+		inFile, err = os.Open(inFilename)
+	}
 	if eh.Invalid(err) {
 		return nil, err
 	}
 	defer inFile.Close()
 
-	// create a scanner that uses the "ScanLines" splitter
-	scanner := bufio.NewScanner(inFile)
-	scanner.Split(bufio.ScanLines)
+	// keep track of the file while it is open, so that pragmas can't refer back to it
+	ctx.openFiles = append(ctx.openFiles, ctx.fileIdentity(inFilename))
+	defer func() {
+		ctx.openFiles = ctx.openFiles[:len(ctx.openFiles)-1]
+	}()
 
-	root := NewBranch()
-	srcLine := 0
-	err = root.ParseBranch(scanner, inFilename, &srcLine, fileOrigin)
-	if err == ErrEndOfBranch {
-		return nil, err
+	return ctx.parseFigtree(inFile, inFilename, fileOrigin)
+}
+
+// Read a file referred to by a pragma on line srcLine of srcFile, after checking that doing so
+// would neither create an include cycle nor exceed the maximum include depth.
+//
+// Returns an *IncludeError, listing the full chain of pragmas, when either check fails.
+func (ctx *readContext) readPragmaFile(srcFile string, srcLine int, pragma string, filename string, fileOrigin FileOrigin) (*Branch, error) {
+	link := IncludeLink{
+		SrcFile:  srcFile,
+		SrcLine:  srcLine,
+		Pragma:   pragma,
+		Filename: filename,
+	}
+	chain := make([]IncludeLink, len(ctx.includeChain), len(ctx.includeChain)+1)
+	copy(chain, ctx.includeChain)
+	chain = append(chain, link)
+
+	identity := ctx.fileIdentity(filename)
+	for _, openFile := range ctx.openFiles {
+		if openFile == identity {
+			return nil, &IncludeError{Chain: chain, Err: ErrIncludeCycle}
+		}
 	}
+	if len(chain) > ctx.maxIncludeDepth {
+		return nil, &IncludeError{Chain: chain, Err: ErrIncludeDepth}
+	}
+
+	savedChain := ctx.includeChain
+	ctx.includeChain = chain
+	defer func() {
+		ctx.includeChain = savedChain
+	}()
+	return ctx.readFigtree(filename, fileOrigin)
+}
+
+// Returns a name that is the same for every path referring to the given file,
+// as far as can be determined without following symbolic links.
+func (ctx *readContext) fileIdentity(filename string) string {
+	if ctx.fsys != nil {
+		return path.Clean(filename)
+	}
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return filepath.Clean(filename)
+	}
+	return absFilename
+}
+
+// Parse every line of the reader into a new root branch.
+func (ctx *readContext) parseFigtree(r io.Reader, srcFile string, fileOrigin FileOrigin) (*Branch, error) {
+	// create a parser that splits lines, enforcing any file size, line length, and depth limits
+	parser := ctx.newEventParser(r, srcFile)
+
+	root := NewBranch()
+	err := root.parseBranch(ctx, parser, fileOrigin)
 	if err != ErrEOF {
 		return nil, err
 	}
 
+	// remember the file's line ending, so that WriteFigtree can reproduce it
+	root.lineEnding = parser.LineEnding()
 	return root, nil
 }
 
 // Recursive function to read lines via a bufio scanner, adding
 // key/value pairs and inner branches to the current branch.
+// The lines are read with an EventParser, whose events are built into the tree.
 // This function is typically only called by the ReadFigtree function,
 // but it may safely be called in userland in order to graft one branch onto another.
 //
-// Returns the ErrEndOfBranch sentinal when finished parsing each inner branch.
-// Return ErrEOF to the outermost caller.
+// Returns ErrEOF to the outermost caller when the scanner is exhausted,
+// or the scanner's error, such as bufio.ErrTooLong, together with the line that could not be read.
+// Returns a *ParseError when a syntax error is found, including a closing brace
+// that has no matching opening brace, which matches ErrEndOfBranch when tested with errors.Is.
 func (branch *Branch) ParseBranch(scanner *bufio.Scanner, srcFile string, srcLine *int, srcOrigin FileOrigin) error {
+	ctx := newReadContext(nil)
+	parser := &EventParser{
+		ctx:     ctx,
+		scanner: scanner,
+		srcFile: srcFile,
+		srcLine: srcLine,
+	}
+	err := branch.parseBranch(ctx, parser, srcOrigin)
+	if ctx.metadata != nil {
+		branch.metadata = ctx.metadata
+	}
+	return err
+}
 
-	blockComments := make([]string, 0) // block comment accumulator
+// Recursive implementation of ParseBranch, sharing the read context with every inner branch and pragma.
+// Each call reads events up to the end of its own branch.
+func (branch *Branch) parseBranch(ctx *readContext, parser *EventParser, srcOrigin FileOrigin) error {
 
-	for scanner.Scan() { // advance the scanner to the end of line
-		var leftSide, rightSide string
-		var key, val, terminalWhitespace, terminalComment string
+	blockComments := make([]string, 0) // block comment accumulator
+	keys := keyChecker{}               // the keys of this branch, for a strict read
 
-		// copy the runes into a string and remove leading and trailing whitespace
-		line := scanner.Text()
-		line = strings.Trim(line, " \t")
-		*srcLine++
+	for {
+		event, err := parser.Next()
+		if err == io.EOF {
+			return ErrEOF
+		}
+		if err != nil {
+			// a tolerant read discards a line with a syntax error and carries on,
+			// but a resource limit or a scanner error is never tolerated
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				return err
+			}
+			err = ctx.report(err, parseErr.SrcFile, parseErr.SrcLine, parseErr.LineText)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 
 		// send blank lines and comment lines to the block comment accumulator
-		if len(line) == 0 || line[0] == '#' {
-			blockComments = append(blockComments, line)
+		if event.Kind == EventComment {
+			blockComments = append(blockComments, event.Text)
 			continue
 		}
 
-		// split into two halves based on first whitespace or opening-brace
-		whitespace := strings.IndexAny(line, " \t{")
-		if whitespace == -1 {
-			leftSide = line
-			rightSide = ""
-			key = leftSide
-		} else {
-			leftSide = line[:whitespace]
-			rightSide = line[whitespace:]
-			key = leftSide
-		}
-
-		// split right side into value and possible comment
-		// hashtags that are not preceded by whitespace are treated as part
-		// of the value, to allow for things like URLs with bookmarks
-		hash := strings.Index(rightSide, "\t#")
-		if hash == -1 {
-			hash = strings.Index(rightSide, " #")
-		}
-		if hash == -1 {
-			val = strings.Trim(rightSide, " \t")
-			terminalWhitespace = ""
-			terminalComment = ""
-		} else {
-			val = strings.TrimLeft(rightSide[:hash+1], " \t")         // keep the trailing whitespace for next step
-			terminalComment = strings.Trim(rightSide[hash+2:], " \t") // drop the whitespace and hash
-
-			// extract the whitespace between value and hash
-			pos := -1
-			for i := len(val) - 1; i >= 0; i-- {
-				if val[i] != ' ' && val[i] != '\t' {
-					pos = i
-					break
+		if event.Kind == EventEndBranch {
+			return ErrEndOfBranch
+		}
+
+		// every other event adds an item
+		if err := ctx.countItem(event.SrcFile, event.SrcLine); err != nil {
+			return err
+		}
+
+		// a tolerant read keeps an item whose key is questionable
+		if event.Key != "!if" && event.Key != "!else" && !(event.Kind == EventBeginBranch && event.Key == "profile" && event.Value != "") {
+			if err := ctx.checkKey(event, &keys); err != nil {
+				err = ctx.report(err, event.SrcFile, event.SrcLine, event.LineText)
+				if err != nil {
+					return err
 				}
 			}
-			if pos != -1 {
-				terminalWhitespace = val[pos+1:]
-				val = val[:pos+1]
-			}
 		}
 
-		// if the right-hand side is "{" create a branch and recurse
-		if len(val) == 1 && val[0] == '{' {
-			// begin branch
-			err := branch.handleBranch(scanner, key, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
+		if event.Kind == EventBeginBranch {
+			// begin branch, which may be a conditional block or a profile block
+			switch {
+			case event.Key == "!if" || event.Key == "!else":
+				err = branch.readConditional(ctx, parser, event, blockComments, srcOrigin)
+			case event.Key == "profile" && event.Value != "":
+				err = branch.readProfile(ctx, parser, event, blockComments, srcOrigin)
+			default:
+				key, appended := splitAppendPrefix(event.Key)
+				first := len(branch.Items)
+				err = branch.handleBranch(ctx, parser, event, key, blockComments, srcOrigin)
+				branch.Items[first].mergeAppend = appended
+			}
 			if err != ErrEndOfBranch {
 				return err
 			}
-		} else if len(leftSide) > 0 && leftSide[0] == '}' {
-			// end branch
-			return ErrEndOfBranch
 		} else {
 			// typical key/value
-			err := branch.handleKeyValuePair(key, val, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
-			if err != nil {
+			if event.Key == "!if" || event.Key == "!else" {
+				// a conditional pragma without an opening brace
+				parseErr := newParseError(event.SrcFile, event.SrcLine, event.LineText, event.Key, ReasonMalformedCondition)
+				parseErr.Key = event.Key
+				err = parseErr
+			} else {
+				var val, rawValue string
+				val, rawValue, err = ctx.expandValue(event)
+				if err == nil {
+					srcLine := event.SrcLine
+					err = branch.handleKeyValuePair(ctx, event.Key, val, rawValue, blockComments, event.TerminalWhitespace, event.TerminalComment, event.SrcFile, &srcLine, event.LineText, srcOrigin)
+				}
+			}
+			var limitErr *LimitError
+			if errors.As(err, &limitErr) {
+				// a resource limit was exceeded, which is never tolerated
 				return err
 			}
+			if err != nil {
+				err = ctx.report(err, event.SrcFile, event.SrcLine, event.LineText)
+				if err != nil {
+					return err
+				}
+			}
 		}
 
 		// reset the block comment accumulator
 		blockComments = make([]string, 0)
 	}
-	return ErrEOF
+}
+
+// Expand the environment variables in the value of a key/value event, keeping the raw value
+// so that it can be written back out, and so that references to other items can be resolved
+// once the whole tree is read. Heredoc values, pragmas, and values within a conditional block
+// that is not taken are never expanded.
+//
+// Returns the value, and the raw value, which is an empty string when there was nothing to expand.
+func (ctx *readContext) expandValue(event Event) (string, string, error) {
+	if event.Heredoc || !ctx.expandEnv || ctx.untakenDepth > 0 || strings.HasPrefix(event.Key, "!") || !hasVariables(event.Value) {
+		return event.Value, "", nil
+	}
+	val, err := expandVariables(event.Value, ctx.lookupEnv, nil)
+	if err != nil {
+		varErr := err.(*variableError)
+		parseErr := newParseError(event.SrcFile, event.SrcLine, event.LineText, varErr.reference, varErr.reason)
+		parseErr.Key = varErr.name
+		parseErr.Detail = varErr.detail
+		return "", "", parseErr
+	}
+	return val, event.Value, nil
 }
 
 // Helper function used by ParseBranch to handle the beginning of a branch
-// by recursively calling ParseBranch.
+// by recursively calling ParseBranch. The key is the key of the new branch item,
+// which is opened by the given BeginBranch event.
 //
-// The normal return is the sentinal ErrEndOfBranch, anything else should halt further processing
-func (branch *Branch) handleBranch(scanner *bufio.Scanner, key string, blockComments []string, terminalWhitespace string, terminalComment string, srcFile string, srcLine *int, srcOrigin FileOrigin) error {
+// The normal return is the sentinal ErrEndOfBranch, anything else should halt further processing.
+// When the file ends before the branch is closed, the parser has already reported it,
+// and a tolerant read returns ErrEOF.
+func (branch *Branch) handleBranch(ctx *readContext, parser *EventParser, event Event, key string, blockComments []string, srcOrigin FileOrigin) error {
 	innerBranch := NewBranch()
-	branch.appendItem(key, innerBranch, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
-	return innerBranch.ParseBranch(scanner, srcFile, srcLine, srcOrigin)
+	branch.appendItem(key, innerBranch, blockComments, event.TerminalWhitespace, event.TerminalComment, event.SrcFile, &event.SrcLine, srcOrigin)
+	return innerBranch.parseBranch(ctx, parser, srcOrigin)
 }
 
 // Helper function used by ParseBranch to handle typical key/value pairs
-// with special detection for the !include, !baseline, and !dtd pragmas.
-func (branch *Branch) handleKeyValuePair(key string, value string, blockComments []string, terminalWhitespace string, terminalComment string, srcFile string, srcLine *int, srcOrigin FileOrigin) error {
+// with special detection for the !include, !include?, !include-as, !include-dir, !baseline, !dtd, and !merge pragmas,
+// and for any pragma added with RegisterPragma. A + prefix on a key is removed, and recorded on the item.
+// The rawValue is the value before variable expansion, or an empty string when there was nothing to expand.
+// The lineText is the line containing the pair, which positions any error in a pragma's value.
+func (branch *Branch) handleKeyValuePair(ctx *readContext, key string, value string, rawValue string, blockComments []string, terminalWhitespace string, terminalComment string, srcFile string, srcLine *int, lineText string, srcOrigin FileOrigin) error {
 
-	if strings.Index(key, "!include") == 0 {
+	if ctx.noFilePragmas && isFilePragma(key) {
+		return &LimitError{Limit: LimitFilePragmas, SrcFile: srcFile, SrcLine: *srcLine, Pragma: key}
+	} else if ctx.untakenDepth > 0 && strings.HasPrefix(key, "!") {
+		// pragmas within a conditional block that is not taken are kept, but not acted upon
+		branch.appendItem(key, value, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
+	} else if handler, ok := lookupPragma(key); ok {
+		branch.appendItem(key, value, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
+		err := branch.readRegisteredPragma(ctx, handler, key, value, srcFile, *srcLine, srcOrigin)
+		if err != nil {
+			return err
+		}
+	} else if key == "!include-dir" {
+		branch.appendItem("!include-dir", value, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
+		err := branch.readIncludeDir(ctx, srcFile, *srcLine, value)
+		if err != nil {
+			return err
+		}
+	} else if key == "!include-as" {
+		branch.appendItem("!include-as", value, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
+		err := branch.readIncludeAs(ctx, srcFile, *srcLine, lineText, srcOrigin, value)
+		if err != nil {
+			return err
+		}
+	} else if key == "!include?" {
+		branch.appendItem("!include?", value, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
+		err := branch.readIncludeFile(ctx, srcFile, *srcLine, lineText, "!include?", value)
+		if err != nil {
+			return err
+		}
+	} else if key == "!include" {
 		branch.appendItem("!include", value, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
-		err := branch.readIncludeFile(value)
+		err := branch.readIncludeFile(ctx, srcFile, *srcLine, lineText, "!include", value)
 		if err != nil {
 			return err
 		}
-	} else if strings.Index(key, "!baseline") == 0 {
+	} else if key == "!baseline" {
 		branch.appendItem("!baseline", value, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
-		err := branch.readBaselineFile(value)
+		err := branch.readBaselineFile(ctx, srcFile, *srcLine, value)
 		if err != nil {
 			return err
 		}
-	} else if strings.Index(key, "!dtd") == 0 {
+	} else if key == "!dtd" {
 		branch.appendItem("!dtd", value, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
-		dtdRootBranch, err := branch.readDtdFile(value)
+		dtdRootBranch, err := branch.readDtdFile(ctx, srcFile, *srcLine, value)
 		if err != nil {
 			return err
 		}
-		todo(dtdRootBranch)
+		ctx.dtdTree = dtdRootBranch
+	} else if key == mergePragma {
+		branch.appendItem(key, value, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
+		if _, _, ok := parseMergeDirective(value); !ok {
+			target := mergePragma
+			if fields := strings.Fields(value); len(fields) > 0 {
+				target = fields[0]
+			}
+			parseErr := newParseError(srcFile, *srcLine, lineText, target, ReasonUnknownMergeMode)
+			parseErr.Key = mergePragma
+			parseErr.Detail = "append, prepend, or replace is required"
+			return parseErr
+		}
 	} else {
+		key, appended := splitAppendPrefix(key)
 		branch.appendItem(key, value, blockComments, terminalWhitespace, terminalComment, srcFile, srcLine, srcOrigin)
+		item := &branch.Items[len(branch.Items)-1]
+		if rawValue != "" {
+			item.rawValue = rawValue
+		}
+		item.mergeAppend = appended
 	}
 	return nil
 }
 
 // Special processing for including key/values from another file.
-// When the filename is not an absolute path, prepend the current working directory.
-func (branch *Branch) readIncludeFile(localFilename string) error {
-	if len(localFilename) > 0 && localFilename[0] != '/' {
-		cwd, _ := os.Getwd()
-		localFilename = path.Join(cwd, localFilename)
+// Relative filenames are resolved against the directory of the including file.
+// A filename containing any of the glob metacharacters * ? or [ is a pattern, and
+// every matching file is included in lexical order. A pattern matching no files is not an error,
+// while a malformed pattern is reported as a *ParseError for the pragma's line.
+//
+// A filename may be followed by a hashtag and a keyPath, such as shared/all.fig#services/web,
+// in which case only the items of the branch at that keyPath are included. A keyPath that
+// does not name a branch of the included file is reported as a *ParseError for the pragma's line.
+//
+// When the pragma is !include? a file that does not exist is silently skipped;
+// errors within a file that does exist are still returned.
+func (branch *Branch) readIncludeFile(ctx *readContext, srcFile string, srcLine int, lineText string, pragma string, localFilename string) error {
+	localFilename, keyPath := ctx.splitIncludeFragment(srcFile, localFilename)
+	includeFilename, err := ctx.resolveFilename(srcFile, localFilename)
+	if err != nil {
+		return err
+	}
+	includeFilenames := []string{includeFilename}
+	if strings.ContainsAny(localFilename, "*?[") {
+		includeFilenames, err = ctx.glob(includeFilename)
+		if err != nil {
+			parseErr := newParseError(srcFile, srcLine, lineText, localFilename, ReasonMalformedPragma)
+			parseErr.Key = pragma
+			parseErr.Detail = err.Error()
+			return parseErr
+		}
+	} else if pragma == "!include?" && !ctx.fileExists(includeFilename) {
+		return nil
+	}
+	for _, includeFilename := range includeFilenames {
+		includeBranch, err := ctx.readPragmaFile(srcFile, srcLine, pragma, includeFilename, IncludeFile)
+		if err != nil {
+			return err
+		}
+		if keyPath != "" {
+			includeBranch, err = includeBranch.GetBranch(keyPath)
+			if err != nil {
+				parseErr := newParseError(srcFile, srcLine, lineText, "#"+keyPath, ReasonIncludeKeyPathNotFound)
+				parseErr.Key = keyPath
+				parseErr.Detail = fmt.Sprintf("%s: %v", includeFilename, err)
+				return parseErr
+			}
+		}
+		branch.Items = append(branch.Items, includeBranch.Items...)
+	}
+	return nil
+}
+
+// Special processing for the !include-as pragma, whose value is a branch key followed by
+// a filename. The included items are grafted onto the current branch under a new inner
+// branch with that key, rather than being spliced directly into the current branch.
+//
+// Returns a *ParseError for the pragma's line when either of them is missing.
+func (branch *Branch) readIncludeAs(ctx *readContext, srcFile string, srcLine int, lineText string, srcOrigin FileOrigin, value string) error {
+	whitespace := strings.IndexAny(value, " \t")
+	if whitespace == -1 {
+		parseErr := newParseError(srcFile, srcLine, lineText, "!include-as", ReasonMalformedPragma)
+		if value != "" {
+			parseErr = newParseError(srcFile, srcLine, lineText, value, ReasonMalformedPragma)
+		}
+		parseErr.Key = "!include-as"
+		parseErr.Detail = "a branch key and a filename are required"
+		return parseErr
 	}
-	includeBranch, err := ReadFigtree(localFilename, IncludeFile)
+	branchKey := value[:whitespace]
+	localFilename := strings.Trim(value[whitespace:], " \t")
+
+	innerBranch := NewBranch()
+	err := innerBranch.readIncludeFile(ctx, srcFile, srcLine, lineText, "!include-as", localFilename)
 	if err != nil {
 		return err
 	}
-	branch.Items = append(branch.Items, includeBranch.Items...)
+	branch.appendItem(branchKey, innerBranch, nil, "", "", srcFile, &srcLine, srcOrigin)
 	return nil
 }
 
-// Special processing for adding a default set of fallback key/values from a baseline file.
-// When the filename is not an absolute path, prepend the current working directory.
-func (branch *Branch) readBaselineFile(localFilename string) error {
-	if len(localFilename) > 0 && localFilename[0] != '/' {
-		cwd, _ := os.Getwd()
-		localFilename = path.Join(cwd, localFilename)
+// Separate an include pragma's value into its filename and its optional keyPath,
+// which follows the last hashtag. The value is only split when the text following the
+// hashtag could be a keyPath, and the whole value is not the name of an existing file,
+// so that files whose names contain a hashtag can still be included.
+func (ctx *readContext) splitIncludeFragment(srcFile string, value string) (filename string, keyPath string) {
+	hash := strings.LastIndex(value, "#")
+	if hash == -1 {
+		return value, ""
+	}
+	keyPath = value[hash+1:]
+	if keyPath == "" || strings.ContainsAny(keyPath, " \t") || strings.HasPrefix(keyPath, "/") || strings.HasSuffix(keyPath, "/") {
+		return value, ""
+	}
+	if wholeFilename, err := ctx.resolveFilename(srcFile, value); err == nil && ctx.fileExists(wholeFilename) {
+		return value, ""
+	}
+	return value[:hash], keyPath
+}
+
+// Special processing for including every file in a directory, in lexical order,
+// in the manner of a conf.d directory. Subdirectories and hidden files are skipped.
+// Relative directory names are resolved against the directory of the including file.
+func (branch *Branch) readIncludeDir(ctx *readContext, srcFile string, srcLine int, localDirname string) error {
+	includeDirname, err := ctx.resolveFilename(srcFile, localDirname)
+	if err != nil {
+		return err
+	}
+	var entries []fs.DirEntry
+	if ctx.fsys != nil {
+		entries, err = fs.ReadDir(ctx.fsys, includeDirname)
+	} else {
+		entries, err = os.ReadDir(includeDirname)
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		includeFilename := ctx.joinFilename(includeDirname, entry.Name())
+		includeBranch, err := ctx.readPragmaFile(srcFile, srcLine, "!include-dir", includeFilename, IncludeFile)
+		if err != nil {
+			return err
+		}
+		branch.Items = append(branch.Items, includeBranch.Items...)
+	}
+	return nil
+}
+
+// Expand a glob pattern into the names of the matching files, in lexical order.
+// As in the shell, hidden files only match a pattern that itself begins with a dot.
+// Directories are skipped.
+//
+// Returns filepath.ErrBadPattern when the pattern is malformed.
+func (ctx *readContext) glob(pattern string) ([]string, error) {
+	var matches []string
+	var err error
+	if ctx.fsys != nil {
+		matches, err = fs.Glob(ctx.fsys, pattern)
+	} else {
+		matches, err = filepath.Glob(pattern)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	filenames := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if strings.HasPrefix(filepath.Base(match), ".") && !strings.HasPrefix(filepath.Base(pattern), ".") {
+			continue
+		}
+		var info fs.FileInfo
+		if ctx.fsys != nil {
+			info, err = fs.Stat(ctx.fsys, match)
+		} else {
+			info, err = os.Stat(match)
+		}
+		if err == nil && info.IsDir() {
+			continue
+		}
+		filenames = append(filenames, match)
 	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+// Determines whether the named file exists on the file system being read.
+func (ctx *readContext) fileExists(filename string) bool {
 	var err error
-	gBaselineTree, err = ReadFigtree(localFilename, BaselineFile)
+	if ctx.fsys != nil {
+		_, err = fs.Stat(ctx.fsys, filename)
+	} else {
+		_, err = os.Stat(filename)
+	}
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// Join a directory name and a filename using the separator of the file system being read.
+func (ctx *readContext) joinFilename(dirname string, filename string) string {
+	if ctx.fsys != nil {
+		return path.Join(dirname, filename)
+	}
+	return filepath.Join(dirname, filename)
+}
+
+// Special processing for adding a default set of fallback key/values from a baseline file.
+// Relative filenames are resolved against the directory of the file declaring the baseline.
+//
+// A baseline file may declare a baseline of its own, forming a chain such as org defaults,
+// team defaults, service defaults, then the user's file. Each level is merged over the one
+// beneath it, so the context's baselineTree holds the whole chain, merged bottom-up, once the
+// user's file has been read. When a file declares several baselines, each is merged over
+// the ones before it.
+func (branch *Branch) readBaselineFile(ctx *readContext, srcFile string, srcLine int, localFilename string) error {
+	baselineFilename, err := ctx.resolveFilename(srcFile, localFilename)
+	if err != nil {
+		return err
+	}
+
+	// set aside any earlier baseline of the declaring file, while reading this one's own baseline
+	earlierBaselineTree := ctx.baselineTree
+	ctx.baselineTree = nil
+	baselineTree, err := ctx.readPragmaFile(srcFile, srcLine, "!baseline", baselineFilename, BaselineFile)
 	if err != nil {
+		ctx.baselineTree = earlierBaselineTree
 		return err
 	}
-	//branch.Items = append(branch.Items, includeBranch.Items...)
+	baselineTree = mergeBaselineWithUser(ctx.baselineTree, baselineTree)
+	ctx.baselineTree = mergeBaselineWithUser(earlierBaselineTree, baselineTree)
 	return nil
 }
 
 // Special processing for parsing a declared document type definition file,
 // which may be used for validation.
-// When the filename is not an absolute path, prepend the current working directory.
+// Relative filenames are resolved against the directory of the file declaring the dtd.
 //
 // Returns the dtd root branch, which should not become part of the user's actual figtree
-func (branch *Branch) readDtdFile(localFilename string) (*Branch, error) {
-	if len(localFilename) > 0 && localFilename[0] != '/' {
-		cwd, _ := os.Getwd()
-		localFilename = path.Join(cwd, localFilename)
+func (branch *Branch) readDtdFile(ctx *readContext, srcFile string, srcLine int, localFilename string) (*Branch, error) {
+	dtdFilename, err := ctx.resolveFilename(srcFile, localFilename)
+	if err != nil {
+		return nil, err
 	}
-	dtdRootBranch, err := ReadFigtree(localFilename, DtdFile)
+	dtdRootBranch, err := ctx.readPragmaFile(srcFile, srcLine, "!dtd", dtdFilename, DtdFile)
 	if err != nil {
 		return nil, err
 	}
 	return dtdRootBranch, nil
 }
+
+// Convert a filename declared by a pragma in srcFile into one that can be opened.
+//
+// On the operating system's file system, a leading "~" is replaced with the user's home
+// directory, and a relative filename is joined to the directory of srcFile, or to the
+// current working directory when the WithWorkingDirPaths option is in effect.
+//
+// On an fs.FS, filenames are unrooted, so any leading slash is dropped, and
+// a relative filename is joined to the directory of srcFile, or to the root
+// of the file system when the WithWorkingDirPaths option is in effect.
+func (ctx *readContext) resolveFilename(srcFile string, localFilename string) (string, error) {
+	if ctx.fsys != nil {
+		if strings.HasPrefix(localFilename, "/") || ctx.workingDirPaths {
+			return strings.TrimPrefix(path.Clean(localFilename), "/"), nil
+		}
+		return path.Join(path.Dir(srcFile), localFilename), nil
+	}
+
+	if localFilename == "~" || strings.HasPrefix(localFilename, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, localFilename[1:]), nil
+	}
+	if filepath.IsAbs(localFilename) {
+		return localFilename, nil
+	}
+	if ctx.workingDirPaths {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(cwd, localFilename), nil
+	}
+	return filepath.Join(filepath.Dir(srcFile), localFilename), nil
+}
@@ -1,16 +1,33 @@
 //=============================================================================
-// File:     reader_test.go
+// File:     read_test.go
 // Tests:    Read success
 //           Read missing input file
-//           Read premature closing brace
-//           Read unmatched opening brace
+//           Read premature closing brace, reported as a ParseError
+//           Read unmatched opening brace, reported as a ParseError
+//           ReadConfigFS with !include and !baseline pragmas
+//           ReadFigtreeFrom
+//           ParseString
+//           ParseError position
+//           Unclosed inner branch
+//           Include relative to the including file, and to the working directory
+//           Include from the home directory
+//           Include glob patterns and directories
+//           Optional include
+//           Include as a named branch, and include a subtree
+//           Multi-level baseline chains
 //=============================================================================
 
 package figtree_test
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/readwritepro/figtree"
 )
@@ -46,16 +63,351 @@ func TestPrematureClosingBrace(t *testing.T) {
 	_, err := figtree.ReadConfig(inFilename)
 
 	expectedErr := figtree.ErrEndOfBranch
-	if expectedErr != err {
+	if !errors.Is(err, expectedErr) {
 		t.Errorf("expected '%v', got '%v'", expectedErr, err)
 	}
+
+	var parseErr *figtree.ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected a *figtree.ParseError, got '%T'", err)
+	}
+	if parseErr.Reason != figtree.ReasonUnexpectedClosingBrace {
+		t.Errorf("expected '%v', got '%v'", figtree.ReasonUnexpectedClosingBrace, parseErr.Reason)
+	}
+
+	expectedMsg := inFilename + ":4:1: unexpected closing brace"
+	if expectedMsg != err.Error() {
+		t.Errorf("expected '%s', got '%s'", expectedMsg, err.Error())
+	}
 }
 
 func TestUnmatchedOpeningBrace(t *testing.T) {
 	inFilename := "testdata/fixtures/unmatched-opening-brace"
 	_, err := figtree.ReadConfig(inFilename)
 
+	expectedErr := figtree.ErrUnclosedBranch
+	if !errors.Is(err, expectedErr) {
+		t.Errorf("expected '%v', got '%v'", expectedErr, err)
+	}
+
+	// the inner branch is closed on line 4, leaving section1 open
+	expectedMsg := inFilename + ":2:10: unclosed opening brace for 'section1'"
+	if err == nil || expectedMsg != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expectedMsg, err)
+	}
+}
+
+func TestReadConfigFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/app.fig":      {Data: []byte("!baseline /etc/defaults.fig\nsection {\n\t!include section.fig\n}\n")},
+		"etc/defaults.fig": {Data: []byte("key1 baseline1\nkey2 baseline2\n")},
+		"etc/section.fig":  {Data: []byte("key3 value3\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "etc/app.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	actual, _ := root.GetValue("key2")
+	expected := "baseline2"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+
+	actual, _ = root.GetValue("section/key3")
+	expected = "value3"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+
+	_, err = figtree.ReadConfigFS(fsys, "etc/missing.fig")
+	if err == nil {
+		t.Errorf("expected an error for a missing file, got 'nil'")
+	}
+}
+
+func TestReadFigtreeFrom(t *testing.T) {
+	r := strings.NewReader("key1 value1\nsection {\n\tkey2 value2\n}\n")
+	root, err := figtree.ReadFigtreeFrom(r, "reader")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	actual, _ := root.GetValue("section/key2")
+	expected := "value2"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+}
+
+func TestParseString(t *testing.T) {
+	root, err := figtree.ParseString("key1 value1    # comment\n")
 	if err != nil {
-		t.Errorf("expected 'nil', got '%v'", err)
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	actual, _ := root.GetValue("key1")
+	expected := "value1"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+}
+
+func TestParseErrorPosition(t *testing.T) {
+	_, err := figtree.ParseString("key1 value1\n\t  }\n")
+
+	var parseErr *figtree.ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected a *figtree.ParseError, got '%v'", err)
+	}
+
+	expected := "string:2:4: unexpected closing brace"
+	if expected != parseErr.Error() {
+		t.Errorf("expected '%s', got '%s'", expected, parseErr.Error())
+	}
+
+	expected = "\t  }"
+	if expected != parseErr.LineText {
+		t.Errorf("expected '%q', got '%q'", expected, parseErr.LineText)
+	}
+}
+
+func TestUnclosedInnerBranch(t *testing.T) {
+	_, err := figtree.ParseString("outer {\n\tinner {\n\t\tkey value\n")
+
+	// the innermost open branch is the one reported
+	expectedMsg := "string:2:8: unclosed opening brace for 'inner'"
+	if err == nil || expectedMsg != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expectedMsg, err)
+	}
+}
+
+func TestIncludeRelativeToIncludingFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/main.fig":         {Data: []byte("!include shared/net.fig\n")},
+		"app/shared/net.fig":   {Data: []byte("!include ports.fig\nhost example.com\n")},
+		"app/shared/ports.fig": {Data: []byte("port 8080\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "app/main.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	actual, _ := root.GetValue("port")
+	expected := "8080"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+
+	// the original behavior resolves against the root of the file system
+	_, err = figtree.ReadConfigFS(fsys, "app/main.fig", figtree.WithWorkingDirPaths())
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected '%v', got '%v'", fs.ErrNotExist, err)
+	}
+
+	// the fixture includes its siblings without naming their directory
+	_, err = figtree.ReadConfig("testdata/fixtures/include-base", figtree.WithWorkingDirPaths())
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected '%v', got '%v'", fs.ErrNotExist, err)
+	}
+}
+
+func TestIncludeFromHomeDir(t *testing.T) {
+	home := t.TempDir()
+	err := os.WriteFile(filepath.Join(home, "defaults.fig"), []byte("key1 from-home\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedHome := os.Getenv("HOME")
+	os.Setenv("HOME", home)
+	defer os.Setenv("HOME", savedHome)
+
+	root, err := figtree.ParseString("!include ~/defaults.fig\n")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	actual, _ := root.GetValue("key1")
+	expected := "from-home"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+}
+
+func TestIncludeGlobAndDir(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.fig":              {Data: []byte("glob {\n\t!include conf.d/*\n}\ndir {\n\t!include-dir conf.d\n}\nnone {\n\t!include missing.d/*.fig\n}\n")},
+		"conf.d/20-second.fig":  {Data: []byte("order second\n")},
+		"conf.d/10-first.fig":   {Data: []byte("order first\n")},
+		"conf.d/30-third.conf":  {Data: []byte("order third\n")},
+		"conf.d/.hidden.fig":    {Data: []byte("order hidden\n")},
+		"conf.d/nested/sub.fig": {Data: []byte("order nested\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "main.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	tests := map[string][]string{
+		"glob/order": {"first", "second", "third"},
+		"dir/order":  {"first", "second", "third"},
+		"none/order": {},
+	}
+	for keyPath, expected := range tests {
+		items := root.QueryAll(keyPath)
+		if len(expected) != len(items) {
+			t.Errorf("%s: expected %d items, got %d", keyPath, len(expected), len(items))
+			continue
+		}
+		for i, item := range items {
+			actual, _ := item.Value()
+			if expected[i] != actual {
+				t.Errorf("%s: expected '%s', got '%s'", keyPath, expected[i], actual)
+			}
+		}
+	}
+
+	// each item keeps the name of the fragment it came from
+	wi := figtree.WriteInternal{}
+	buf, _ := root.WriteToBuffer(wi)
+	if !strings.Contains(buf, "(Incl)[10-first.fig:1]") || !strings.Contains(buf, "(Incl)[30-third.conf:1]") {
+		t.Errorf("expected the fragment filenames, got\n%s", buf)
+	}
+
+	// a malformed pattern is reported at the pragma's position
+	_, err = figtree.ParseString("key value\n!include testdata/fixtures/[\n")
+	var parseErr *figtree.ParseError
+	if !errors.As(err, &parseErr) || parseErr.Reason != figtree.ReasonMalformedPragma {
+		t.Errorf("expected '%v', got '%v'", figtree.ReasonMalformedPragma, err)
+	}
+	expected := "string:2:10: malformed pragma for '!include': " + filepath.ErrBadPattern.Error()
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+}
+
+func TestOptionalInclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.fig":        {Data: []byte("key1 value1\n!include? local-overrides.fig\nkey2 value2\n")},
+		"broken.fig":      {Data: []byte("!include? bad-syntax.fig\n")},
+		"bad-syntax.fig":  {Data: []byte("}\n")},
+		"nested.fig":      {Data: []byte("!include? has-missing.fig\n")},
+		"has-missing.fig": {Data: []byte("!include missing.fig\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "main.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	if !root.ItemExists("key2") {
+		t.Errorf("expected the items following an absent optional include")
+	}
+
+	// when the optional file exists, its problems are still reported
+	_, err = figtree.ReadConfigFS(fsys, "broken.fig")
+	if !errors.Is(err, figtree.ErrEndOfBranch) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrEndOfBranch, err)
+	}
+	_, err = figtree.ReadConfigFS(fsys, "nested.fig")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected '%v', got '%v'", fs.ErrNotExist, err)
+	}
+}
+
+func TestIncludeAsAndSubtree(t *testing.T) {
+	fsys := fstest.MapFS{
+		"service.fig":         {Data: []byte("!include-as network shared/net.fig\nweb {\n\t!include shared/all.fig#services/web\n}\n")},
+		"shared/net.fig":      {Data: []byte("host example.com\nport 443\n")},
+		"shared/all.fig":      {Data: []byte("services {\n\tweb {\n\t\tworkers 8\n\t}\n\tdb {\n\t\tworkers 2\n\t}\n}\n")},
+		"bad-path.fig":        {Data: []byte("!include shared/all.fig#services/missing\n")},
+		"bad-as.fig":          {Data: []byte("!include-as shared/net.fig\n")},
+		"hashtag.fig":         {Data: []byte("!include shared/odd#name.fig\n")},
+		"shared/odd#name.fig": {Data: []byte("odd true\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "service.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	tests := map[string]string{
+		"network/host": "example.com",
+		"network/port": "443",
+		"web/workers":  "8",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", keyPath, expected, actual, err)
+		}
+	}
+	if root.PathExists("web/services") || root.PathExists("web/db") {
+		t.Errorf("expected only the items of services/web to be included")
+	}
+
+	// the errors point to the pragma's line
+	_, err = figtree.ReadConfigFS(fsys, "bad-path.fig")
+	if !errors.Is(err, figtree.ErrNotFound) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrNotFound, err)
+	}
+	expected := "bad-path.fig:1:24: keyPath not found for 'services/missing': shared/all.fig: figtree: item not found"
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+	_, err = figtree.ReadConfigFS(fsys, "bad-as.fig")
+	if !errors.Is(err, figtree.ErrSyntax) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrSyntax, err)
+	}
+	expected = "bad-as.fig:1:13: malformed pragma for '!include-as': a branch key and a filename are required"
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+
+	// a filename containing a hashtag is included whole
+	root, err = figtree.ReadConfigFS(fsys, "hashtag.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	if actual, _ := root.GetValue("odd"); actual != "true" {
+		t.Errorf("expected 'true', got '%s'", actual)
+	}
+}
+
+func TestBaselineChain(t *testing.T) {
+	fsys := fstest.MapFS{
+		"org.fig":     {Data: []byte("timeout 30\nretries 3\nlog-level warn\nowner org\n")},
+		"team.fig":    {Data: []byte("!baseline org.fig\nretries 5\nowner team\n")},
+		"service.fig": {Data: []byte("!baseline team.fig\nlog-level info\nowner service\n")},
+		"user.fig":    {Data: []byte("!baseline service.fig\nowner user\n")},
+		"loop-a.fig":  {Data: []byte("!baseline loop-b.fig\n")},
+		"loop-b.fig":  {Data: []byte("!baseline loop-a.fig\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "user.fig")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	// each level overrides the levels beneath it
+	tests := map[string]string{
+		"timeout":   "30",
+		"retries":   "5",
+		"log-level": "info",
+		"owner":     "user",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", keyPath, expected, actual, err)
+		}
+	}
+
+	// every value records the file and origin that supplied it
+	wi := figtree.WriteInternal{}
+	buf, _ := root.WriteToBuffer(wi)
+	for _, expected := range []string{"(Base)[org.fig:1]", "(Base)[team.fig:2]", "(Base)[service.fig:2]", "(User)[user.fig:2]"} {
+		if !strings.Contains(buf, expected) {
+			t.Errorf("expected '%s' in\n%s", expected, buf)
+		}
+	}
+
+	_, err = figtree.ReadConfigFS(fsys, "loop-a.fig")
+	if !errors.Is(err, figtree.ErrIncludeCycle) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrIncludeCycle, err)
 	}
 }
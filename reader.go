@@ -0,0 +1,111 @@
+//=============================================================================
+// File:     reader.go
+// Contents: Reader type declaration
+//           NewReader
+//           ReadConfig, ReadConfigFS, ReadFigtree, ReadFigtreeFrom, ParseString,
+//            ReadConfigTolerant, ReadFigtreeFromTolerant methods
+//=============================================================================
+
+package figtree
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// The Reader type holds a set of options that are applied to every read made with it.
+// The package-level read functions are thin wrappers that create a Reader for a single call.
+//
+// Each read keeps its own state, such as the baseline tree built by a !baseline pragma,
+// for the duration of that read only. A Reader is never modified after it is created,
+// so it may be shared by any number of goroutines reading configurations at the same time.
+type Reader struct {
+	options []ReadOption
+}
+
+// The NewReader function creates a Reader that applies the given options, in order, to every read.
+func NewReader(options ...ReadOption) *Reader {
+	reader := Reader{
+		options: append([]ReadOption(nil), options...),
+	}
+	return &reader
+}
+
+// The ReadConfig method reads a user's configuration file, and merges it with its baseline,
+// in the same way as the ReadConfig function.
+func (reader *Reader) ReadConfig(inFilename string) (*Branch, error) {
+	ctx := newReadContext(reader.options)
+	return ctx.readConfig(inFilename)
+}
+
+// The ReadConfigFS method reads a user's configuration file from the given file system,
+// in the same way as the ReadConfigFS function.
+func (reader *Reader) ReadConfigFS(fsys fs.FS, name string) (*Branch, error) {
+	ctx := newReadContext(reader.options)
+	ctx.fsys = fsys
+	return ctx.readConfig(name)
+}
+
+// The ReadFigtree method opens, parses, and closes the given file,
+// in the same way as the ReadFigtree function.
+func (reader *Reader) ReadFigtree(inFilename string, fileOrigin FileOrigin) (*Branch, error) {
+	ctx := newReadContext(reader.options)
+	root, err := ctx.readFigtree(inFilename, fileOrigin)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.finishTree(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// The ReadFigtreeFrom method parses figtree syntax from the given reader,
+// in the same way as the ReadFigtreeFrom function.
+func (reader *Reader) ReadFigtreeFrom(r io.Reader, srcFile string) (*Branch, error) {
+	ctx := newReadContext(reader.options)
+	root, err := ctx.parseFigtree(r, srcFile, UserFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.finishTree(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// The ParseString method parses a string containing figtree syntax,
+// in the same way as the ParseString function.
+func (reader *Reader) ParseString(figtreeSyntax string) (*Branch, error) {
+	return reader.ReadFigtreeFrom(strings.NewReader(figtreeSyntax), "string")
+}
+
+// The ReadConfigTolerant method reads a user's configuration file, collecting every problem
+// rather than stopping at the first one, in the same way as the ReadConfigTolerant function.
+func (reader *Reader) ReadConfigTolerant(inFilename string) (*Branch, []Diagnostic) {
+	ctx := newReadContext(reader.options)
+	ctx.tolerant = true
+	root, err := ctx.mergeConfig(inFilename)
+	if err != nil {
+		ctx.report(err, inFilename, 0, "")
+	} else if err := ctx.finishTree(root); err != nil {
+		ctx.report(err, inFilename, 0, "")
+	}
+	return root, ctx.diagnostics
+}
+
+// The ReadFigtreeFromTolerant method parses figtree syntax from the given reader, collecting
+// every problem rather than stopping at the first one, in the same way as the
+// ReadFigtreeFromTolerant function.
+func (reader *Reader) ReadFigtreeFromTolerant(r io.Reader, srcFile string) (*Branch, []Diagnostic) {
+	ctx := newReadContext(reader.options)
+	ctx.tolerant = true
+	root, err := ctx.parseFigtree(r, srcFile, UserFile)
+	if err != nil {
+		ctx.report(err, srcFile, 0, "")
+	} else if err := ctx.finishTree(root); err != nil {
+		ctx.report(err, srcFile, 0, "")
+	}
+	return root, ctx.diagnostics
+}
@@ -0,0 +1,64 @@
+//=============================================================================
+// File:     reader_test.go
+// Tests:    A Reader applies its options to every read
+//           Concurrent reads of configurations with different baselines
+//=============================================================================
+
+package figtree_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/readwritepro/figtree"
+)
+
+func TestReaderOptions(t *testing.T) {
+	reader := figtree.NewReader(figtree.WithVariables(map[string]string{"env": "production"}))
+	for i := 0; i < 2; i++ {
+		root, err := reader.ParseString("!if env == production {\n\tport 443\n}\n")
+		if err != nil {
+			t.Fatalf("expected 'nil', got '%v'", err)
+		}
+		actual, _ := root.GetValue("port")
+		if actual != "443" {
+			t.Errorf("expected '443', got '%s'", actual)
+		}
+	}
+}
+
+func TestConcurrentReads(t *testing.T) {
+	const count = 8
+	fsys := fstest.MapFS{}
+	for i := 0; i < count; i++ {
+		fsys[fmt.Sprintf("base%d.fig", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf("owner base%d\nshared baseline\n", i))}
+		fsys[fmt.Sprintf("user%d.fig", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf("!baseline base%d.fig\nname user%d\n", i, i))}
+	}
+
+	// every read must see its own baseline, and none of the others
+	reader := figtree.NewReader()
+	var wg sync.WaitGroup
+	errs := make(chan error, count*10)
+	for i := 0; i < count*10; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			root, err := reader.ReadConfigFS(fsys, fmt.Sprintf("user%d.fig", n))
+			if err != nil {
+				errs <- err
+				return
+			}
+			actual, _ := root.GetValue("owner")
+			if expected := fmt.Sprintf("base%d", n); expected != actual {
+				errs <- fmt.Errorf("expected '%s', got '%s'", expected, actual)
+			}
+		}(i % count)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,169 @@
+//=============================================================================
+// File:     reference.go
+// Contents: ReferenceLink type declaration
+//           ReferenceError type declaration
+//           ResolveReferences resolves ${key/path} references between items
+//=============================================================================
+
+package figtree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The ReferenceLink type records one item in a chain of ${key/path} references:
+// the item's keyPath and position, and the reference it contains.
+type ReferenceLink struct {
+	KeyPath   string // the keyPath of the item containing the reference
+	SrcFile   string // the file containing the item
+	SrcLine   int    // the 1-based line number of the item
+	Reference string // the reference, such as "${paths/base}"
+}
+
+func (link ReferenceLink) String() string {
+	return fmt.Sprintf("%s:%d %s refers to %s", link.SrcFile, link.SrcLine, link.KeyPath, link.Reference)
+}
+
+// The ReferenceError type is returned when a ${key/path} reference can't be resolved.
+// The Err field is ErrReferenceCycle when the chain refers back to an item that is still
+// being resolved, ErrNotFound when the referenced item does not exist, or ErrNotLeaf when
+// it is a branch, and is matched by errors.Is.
+type ReferenceError struct {
+	Chain []ReferenceLink // every reference in the chain, starting with the item being resolved
+	Err   error
+}
+
+// Formats the error as the sentinel's message followed by each link of the chain.
+func (e *ReferenceError) Error() string {
+	links := make([]string, 0, len(e.Chain))
+	for _, link := range e.Chain {
+		links = append(links, link.String())
+	}
+	return fmt.Sprintf("%v: %s", e.Err, strings.Join(links, ", "))
+}
+
+// Returns ErrReferenceCycle, ErrNotFound, or ErrNotLeaf.
+func (e *ReferenceError) Unwrap() error {
+	return e.Err
+}
+
+// The referenceResolver type holds the state of a single pass over a tree.
+type referenceResolver struct {
+	root      *Branch
+	lookupEnv func(string) (string, bool)
+	resolved  map[*Item]bool
+	visiting  []*Item         // the items currently being resolved, outermost first
+	chain     []ReferenceLink // parallel to visiting
+}
+
+// The ResolveReferences function replaces the value of every leaf item that refers to
+// another item, using a ${key/path} reference, with the referenced item's value.
+// Any ${NAME} environment variables within those values are expanded again at the same time.
+// A keyPath beginning with a slash refers to an item at the root of the tree.
+//
+// When the WithEnvExpansion option is given, ReadConfig calls this after merging the user's file
+// with its baseline, so references may refer to items in either file. Programs that alter
+// referenced values may call it again: each value is recomputed from its raw value, which is
+// kept unchanged.
+//
+// Returns a *ReferenceError for the first reference that can't be resolved, or a *ParseError
+// when a required environment variable is no longer set.
+func (branch *Branch) ResolveReferences() error {
+	return branch.resolveReferences(newReadContext([]ReadOption{WithEnvExpansion()}))
+}
+
+// Resolve every reference in the tree rooted at this branch, reporting problems through the context.
+func (branch *Branch) resolveReferences(ctx *readContext) error {
+	if !ctx.expandEnv {
+		return nil
+	}
+	resolver := &referenceResolver{
+		root:      branch,
+		lookupEnv: ctx.lookupEnv,
+		resolved:  make(map[*Item]bool),
+	}
+	return resolver.resolveBranch(ctx, branch, "")
+}
+
+// Resolve the items of one branch, whose keyPath begins with the given prefix.
+func (resolver *referenceResolver) resolveBranch(ctx *readContext, branch *Branch, prefix string) error {
+	for i := range branch.Items {
+		item := &branch.Items[i]
+		if innerBranch, ok := item.value.(*Branch); ok {
+			if err := resolver.resolveBranch(ctx, innerBranch, prefix+item.key+"/"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := resolver.resolveItem(item, prefix+item.key); err != nil {
+			// a tolerant read records the problem and leaves the value unresolved
+			if err = ctx.report(err, item.srcFile, item.srcLine, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Recompute the item's value from its raw value, first resolving any items that it refers to.
+func (resolver *referenceResolver) resolveItem(item *Item, keyPath string) error {
+	if item.rawValue == "" || resolver.resolved[item] {
+		return nil
+	}
+
+	resolver.visiting = append(resolver.visiting, item)
+	resolver.chain = append(resolver.chain, ReferenceLink{
+		KeyPath: keyPath,
+		SrcFile: item.srcFile,
+		SrcLine: item.srcLine,
+	})
+	defer func() {
+		resolver.visiting = resolver.visiting[:len(resolver.visiting)-1]
+		resolver.chain = resolver.chain[:len(resolver.chain)-1]
+	}()
+
+	lookupRef := func(refKeyPath string) (string, error) {
+		resolver.chain[len(resolver.chain)-1].Reference = "${" + refKeyPath + "}"
+
+		target, err := resolver.root.GetItem(refKeyPath)
+		if err != nil {
+			return "", resolver.newError(len(resolver.chain)-1, ErrNotFound)
+		}
+		for i, visiting := range resolver.visiting {
+			if visiting == target {
+				return "", resolver.newError(i, ErrReferenceCycle)
+			}
+		}
+		if err := resolver.resolveItem(target, strings.TrimPrefix(refKeyPath, "/")); err != nil {
+			return "", err
+		}
+		value, err := target.Value()
+		if err != nil {
+			return "", resolver.newError(len(resolver.chain)-1, ErrNotLeaf)
+		}
+		return value, nil
+	}
+
+	value, err := expandVariables(item.rawValue, resolver.lookupEnv, lookupRef)
+	if varErr, ok := err.(*variableError); ok {
+		// an environment variable that has been unset since the file was read
+		parseErr := newParseError(item.srcFile, item.srcLine, "", varErr.reference, varErr.reason)
+		parseErr.Key = varErr.name
+		parseErr.Detail = varErr.detail
+		return parseErr
+	}
+	if err != nil {
+		return err
+	}
+	item.value = value
+	resolver.resolved[item] = true
+	return nil
+}
+
+// Create an error listing the links of the chain from the given index onwards.
+func (resolver *referenceResolver) newError(from int, sentinel error) *ReferenceError {
+	chain := make([]ReferenceLink, len(resolver.chain)-from)
+	copy(chain, resolver.chain[from:])
+	return &ReferenceError{Chain: chain, Err: sentinel}
+}
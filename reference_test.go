@@ -0,0 +1,94 @@
+//=============================================================================
+// File:     reference_test.go
+// Tests:    Resolve ${key/path} references after merging with the baseline
+//           Raw and resolved values
+//           Reference cycles and missing items
+//           ResolveReferences after altering a referenced value
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/readwritepro/figtree"
+)
+
+func TestResolveReferences(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.fig": {Data: []byte("paths {\n\tbase /opt/app\n}\nlog-dir ${paths/base}/logs\n")},
+		"user.fig": {Data: []byte("!baseline base.fig\npaths {\n\tbase /srv/app\n}\narchive-dir ${/log-dir}/archive\nprice $${paths/base}\n")},
+	}
+	root, err := figtree.ReadConfigFS(fsys, "user.fig", figtree.WithEnvExpansion())
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+
+	// the user's value overrides the baseline's before the reference is resolved
+	tests := map[string]string{
+		"log-dir":     "/srv/app/logs",
+		"archive-dir": "/srv/app/logs/archive",
+		"price":       "${paths/base}",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected '%s', got '%s' (%v)", keyPath, expected, actual, err)
+		}
+	}
+
+	item, _ := root.GetItem("archive-dir")
+	actual, _ := item.RawValue()
+	expected := "${/log-dir}/archive"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+}
+
+func TestReferenceCycle(t *testing.T) {
+	_, err := figtree.ParseString("a ${/b}\nsection {\n\tb ${/a}\n}\nb ${section/b}\n", figtree.WithEnvExpansion())
+	if !errors.Is(err, figtree.ErrReferenceCycle) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrReferenceCycle, err)
+	}
+	expected := "figtree: reference cycle: string:1 a refers to ${/b}, string:5 b refers to ${section/b}, string:3 section/b refers to ${/a}"
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+
+	_, err = figtree.ParseString("key1 value1\nkey2 ${missing/key}\n", figtree.WithEnvExpansion())
+	if !errors.Is(err, figtree.ErrNotFound) {
+		t.Errorf("expected '%v', got '%v'", figtree.ErrNotFound, err)
+	}
+	expected = "figtree: item not found: string:2 key2 refers to ${missing/key}"
+	if err == nil || expected != err.Error() {
+		t.Errorf("expected '%s', got '%v'", expected, err)
+	}
+
+	root, diagnostics := figtree.ReadFigtreeFromTolerant(strings.NewReader("key1 ${section/}\nkey2 ${/key2}\nkey3 value3\n"), "string", figtree.WithEnvExpansion())
+	if root == nil || len(diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", diagnostics)
+	}
+	if !errors.Is(diagnostics[1].Err, figtree.ErrReferenceCycle) || diagnostics[1].SrcLine != 2 {
+		t.Errorf("expected '%v' on line 2, got '%v'", figtree.ErrReferenceCycle, diagnostics[1])
+	}
+}
+
+func TestResolveReferencesAgain(t *testing.T) {
+	root, err := figtree.ParseString("paths {\n\tbase /opt/app\n}\nlog-dir ${paths/base}/logs\n", figtree.WithEnvExpansion())
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	base, _ := root.GetItem("paths/base")
+	base.SetValue("/var/app")
+	if err := root.ResolveReferences(); err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	actual, _ := root.GetValue("log-dir")
+	expected := "/var/app/logs"
+	if expected != actual {
+		t.Errorf("expected '%s', got '%s'", expected, actual)
+	}
+}
@@ -0,0 +1,115 @@
+//=============================================================================
+// File:     scanner.go
+// Contents: newScanner creates the line scanner used to read every file
+//           lineEndingCounter type declaration
+//           Byte order mark and UTF-8 validation helpers
+//           crlfWriter type declaration
+//=============================================================================
+
+package figtree
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+// The UTF-8 byte order mark, which some editors write at the start of a file.
+const byteOrderMark = "\uFEFF"
+
+// The largest value of an int, used as the scanner's buffer size when line length is not limited.
+const maxInt = int(^uint(0) >> 1)
+
+// The lineEndingCounter type counts the two styles of line ending seen by a scanner.
+type lineEndingCounter struct {
+	crlf int // lines ending with a carriage return and line feed
+	lf   int // lines ending with a line feed alone
+}
+
+// The line ending used by most of the lines counted, which is "\n" unless most were "\r\n".
+func (counter *lineEndingCounter) lineEnding() string {
+	if counter.crlf > counter.lf {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// Create a scanner for the given file that splits it into lines, dropping the carriage return
+// of any "\r\n" line ending, and counting the line endings of each style. The context's file
+// size and line length limits are enforced; exceeding either halts the scanner, and the
+// *LimitError is available from its Err function.
+//
+// The scanner's buffer starts small and grows as needed, up to the maximum line length,
+// so lines longer than bufio.MaxScanTokenSize can be read.
+func (ctx *readContext) newScanner(r io.Reader, srcFile string) (*bufio.Scanner, *lineEndingCounter) {
+	if ctx.maxFileSize > 0 {
+		r = &sizeLimitedReader{
+			r:         r,
+			remaining: ctx.maxFileSize,
+			err:       &LimitError{Limit: LimitFileSize, Max: ctx.maxFileSize, SrcFile: srcFile},
+		}
+	}
+	scanner := bufio.NewScanner(r)
+
+	// leave room for a carriage return, and for one byte beyond the limit, so that it can be detected
+	maxLineLength := ctx.maxLineLength
+	if maxLineLength > 0 {
+		scanner.Buffer(make([]byte, 0, 4096), maxLineLength+3)
+	} else {
+		scanner.Buffer(make([]byte, 0, 4096), maxInt)
+	}
+
+	counter := &lineEndingCounter{}
+	lineCount := 0
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			lineCount++
+			if advance > 0 && data[advance-1] == '\n' {
+				if advance > 1 && data[advance-2] == '\r' {
+					counter.crlf++
+				} else {
+					counter.lf++
+				}
+			}
+		}
+		// a line that is too long, or a partial line that is already too long
+		if maxLineLength > 0 && (len(token) > maxLineLength || (token == nil && len(data) > maxLineLength+1)) {
+			line := lineCount
+			if token == nil {
+				line++
+			}
+			return 0, nil, &LimitError{Limit: LimitLineLength, Max: int64(maxLineLength), SrcFile: srcFile, SrcLine: line}
+		}
+		return advance, token, err
+	})
+	return scanner, counter
+}
+
+// Find the first byte of the string that is not part of a valid UTF-8 sequence.
+//
+// Returns -1 when the whole string is valid.
+func invalidUTF8Offset(s string) int {
+	for offset := 0; offset < len(s); {
+		r, size := utf8.DecodeRuneInString(s[offset:])
+		if r == utf8.RuneError && size == 1 {
+			return offset
+		}
+		offset += size
+	}
+	return -1
+}
+
+// The crlfWriter type converts each line feed written to it into a carriage return and line feed.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (cw crlfWriter) Write(p []byte) (int, error) {
+	_, err := cw.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n")))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,72 @@
+//=============================================================================
+// File:     scanner_test.go
+// Tests:    Byte order mark and CRLF line endings are stripped
+//           WriteFigtree reproduces CRLF line endings
+//           Invalid UTF-8 is reported with its position
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/readwritepro/figtree"
+)
+
+func TestByteOrderMarkAndCRLF(t *testing.T) {
+	input := "\uFEFFkey1 value1\r\nsection {\r\n\tkey2 value2\t# comment\r\n}\r\n"
+	root, err := figtree.ParseString(input + "script <<END\r\necho one\r\necho two\r\nEND\r\n")
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	tests := map[string]string{
+		"key1":         "value1",
+		"section/key2": "value2",
+		"script":       "echo one\necho two",
+	}
+	for keyPath, expected := range tests {
+		actual, err := root.GetValue(keyPath)
+		if err != nil || expected != actual {
+			t.Errorf("%s: expected %q, got %q (%v)", keyPath, expected, actual, err)
+		}
+	}
+
+	// the line endings are reproduced, but not the byte order mark
+	root, err = figtree.ParseString(input)
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	wf := figtree.WriteFigtree{}
+	actual, _ := root.WriteToBuffer(wf)
+	expected := strings.TrimPrefix(input, "\uFEFF")
+	if expected != actual {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+
+	root, _ = figtree.ParseString("key1 value1\nkey2 value2\n")
+	actual, _ = root.WriteToBuffer(wf)
+	if strings.Contains(actual, "\r") {
+		t.Errorf("expected LF line endings, got %q", actual)
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	tests := map[string]string{
+		"key1 value1\nkey2 caf\xe9\n":                  "string:2:9: invalid UTF-8",
+		"key1 value1\nscript <<END\nok\nb\xffd\nEND\n": "string:4:2: invalid UTF-8",
+	}
+	for input, expected := range tests {
+		_, err := figtree.ParseString(input)
+		if !errors.Is(err, figtree.ErrSyntax) || expected != err.Error() {
+			t.Errorf("expected '%s', got '%v'", expected, err)
+		}
+	}
+
+	// a tolerant read discards the line and carries on
+	root, diagnostics := figtree.ReadFigtreeFromTolerant(strings.NewReader("key1 \xc3\x28\nkey2 value2\n"), "string")
+	if len(diagnostics) != 1 || !root.ItemExists("key2") || root.ItemExists("key1") {
+		t.Errorf("expected a single diagnostic, got %v", diagnostics)
+	}
+}
@@ -0,0 +1,90 @@
+//=============================================================================
+// File:     strict.go
+// Contents: keyChecker type declaration
+//           checkKey validates keys for pragmas and for a strict read
+//=============================================================================
+
+package figtree
+
+import (
+	"strings"
+)
+
+// The keyChecker type remembers the keys written directly within a single branch of a file,
+// and the keys declared as arrays by its !array pragmas, so that a strict read can reject
+// a key that is repeated without being declared as an array. A key ending in [] is always
+// an array, as it is for the writers.
+type keyChecker struct {
+	seen   map[string]bool
+	arrays map[string]bool
+}
+
+// Check the key of a KeyValue event, or of a BeginBranch event for a plain branch.
+//
+// A key beginning with an exclamation mark that is neither a built-in pragma nor one added with
+// RegisterPragma is an error. A strict read also rejects empty keys, keys that the writers could
+// not write back out to be read as the same key, and repeated keys.
+//
+// Returns a *ParseError for the first problem found, or nil.
+func (ctx *readContext) checkKey(event Event, keys *keyChecker) error {
+	key := event.Key
+	if len(key) > 1 && key[0] == '!' && !isKnownPragma(key) {
+		parseErr := newParseError(event.SrcFile, event.SrcLine, event.LineText, key, ReasonUnknownPragma)
+		parseErr.Key = key
+		return parseErr
+	}
+	if !ctx.strict {
+		return nil
+	}
+
+	if key == "" {
+		return newParseError(event.SrcFile, event.SrcLine, event.LineText, strings.TrimLeft(event.LineText, " \t"), ReasonEmptyKey)
+	}
+	if target, ok := unwritableKeyText(key); ok {
+		parseErr := newParseError(event.SrcFile, event.SrcLine, event.LineText, target, ReasonUnwritableKey)
+		parseErr.Key = key
+		return parseErr
+	}
+
+	// pragmas, such as !include, may be repeated, and an !array pragma declares keys that may be
+	if key[0] == '!' {
+		if key == "!array" && event.Kind == EventKeyValue {
+			if keys.arrays == nil {
+				keys.arrays = make(map[string]bool)
+			}
+			for _, name := range strings.Fields(event.Value) {
+				keys.arrays[name] = true
+			}
+		}
+		return nil
+	}
+
+	// a key with a + prefix is appended to an array when merging, so it may be repeated
+	if key[0] == '+' {
+		return nil
+	}
+	if keys.seen == nil {
+		keys.seen = make(map[string]bool)
+	}
+	if keys.seen[key] && !keys.arrays[key] && !strings.HasSuffix(key, "[]") {
+		parseErr := newParseError(event.SrcFile, event.SrcLine, event.LineText, key, ReasonDuplicateKey)
+		parseErr.Key = key
+		return parseErr
+	}
+	keys.seen[key] = true
+	return nil
+}
+
+// Find the part of a key that would not survive being written out and read back again:
+// an opening brace, which would begin a branch, a hashtag, which may begin a comment,
+// a solidus, which separates the keys of a keyPath, or whitespace or a control character.
+//
+// Returns false when the whole key can be written.
+func unwritableKeyText(key string) (string, bool) {
+	for _, r := range key {
+		if strings.ContainsRune("{#/ ", r) || r < 0x20 || r == 0x7F {
+			return string(r), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,130 @@
+//=============================================================================
+// File:     strict_test.go
+// Tests:    Pragmas are matched exactly, and unknown pragmas are reported
+//           A strict read rejects duplicate, empty, and unwritable keys
+//           Keys ending in [] may be repeated in a strict read
+//           A tolerant strict read reports every problem and keeps the items
+//=============================================================================
+
+package figtree_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/readwritepro/figtree"
+)
+
+func TestExactPragmaMatching(t *testing.T) {
+	input := "!includes nonexistent\n!baseline-old nonexistent\n"
+
+	// keys that merely begin with a pragma are unknown pragmas, with or without WithStrict
+	for _, options := range [][]figtree.ReadOption{nil, {figtree.WithStrict()}} {
+		_, err := figtree.ParseString(input, options...)
+		var parseErr *figtree.ParseError
+		if !errors.As(err, &parseErr) || parseErr.Reason != figtree.ReasonUnknownPragma || parseErr.SrcLine != 1 {
+			t.Errorf("expected '%v' on line 1, got '%v'", figtree.ReasonUnknownPragma, err)
+		}
+	}
+
+	// a tolerant read reports each of them, and keeps them as ordinary keys
+	root, diagnostics := figtree.ReadFigtreeFromTolerant(strings.NewReader(input), "string")
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got '%v'", diagnostics)
+	}
+	expected := "string:1:1: error: unknown pragma for '!includes'"
+	if diagnostics[0].Severity != figtree.SeverityError || expected != diagnostics[0].String() {
+		t.Errorf("expected '%s', got '%v'", expected, diagnostics[0])
+	}
+	for _, key := range []string{"!includes", "!baseline-old"} {
+		if actual, _ := root.GetValue(key); actual != "nonexistent" {
+			t.Errorf("%s: expected 'nonexistent', got '%s'", key, actual)
+		}
+	}
+
+	// the WithoutFilePragmas option refuses only the pragmas themselves
+	_, err := figtree.ParseString(input, figtree.WithoutFilePragmas())
+	if errors.Is(err, figtree.ErrLimitExceeded) {
+		t.Errorf("expected '%v', got '%v'", figtree.ReasonUnknownPragma, err)
+	}
+
+	// a key without a value is an unknown pragma too, which a tolerant read keeps as an ordinary key
+	input = "!foo\n"
+	_, err = figtree.ParseString(input)
+	var parseErr *figtree.ParseError
+	if !errors.As(err, &parseErr) || parseErr.Reason != figtree.ReasonUnknownPragma {
+		t.Errorf("expected '%v', got '%v'", figtree.ReasonUnknownPragma, err)
+	}
+	root, diagnostics = figtree.ReadFigtreeFromTolerant(strings.NewReader(input), "string")
+	if len(diagnostics) != 1 || diagnostics[0].Severity != figtree.SeverityError || !root.ItemExists("!foo") {
+		t.Errorf("expected an error, and '!foo' to be kept, got '%v'", diagnostics)
+	}
+}
+
+func TestStrictKeys(t *testing.T) {
+	tests := []struct {
+		input  string
+		reason figtree.ParseReason
+		line   int
+	}{
+		{"ns ns1\nns ns2\n", figtree.ReasonDuplicateKey, 2},
+		{"section {\n}\nsection {\n}\n", figtree.ReasonDuplicateKey, 3},
+		{"key value\n{ value\n", figtree.ReasonEmptyKey, 2},
+		{"paths/base /srv\n", figtree.ReasonUnwritableKey, 1},
+		{"with#hash value\n", figtree.ReasonUnwritableKey, 1},
+	}
+	for _, test := range tests {
+		_, err := figtree.ParseString(test.input)
+		if err != nil {
+			t.Errorf("%q: expected 'nil' without WithStrict, got '%v'", test.input, err)
+		}
+		_, err = figtree.ParseString(test.input, figtree.WithStrict())
+		var parseErr *figtree.ParseError
+		if !errors.As(err, &parseErr) || parseErr.Reason != test.reason || parseErr.SrcLine != test.line {
+			t.Errorf("%q: expected '%v' on line %d, got '%v'", test.input, test.reason, test.line, err)
+		}
+	}
+
+	// keys declared as arrays may be repeated, and the same key may appear in different branches
+	input := "name-servers {\n\t!array ns mx\n\tns ns1\n\tns ns2\n\tmx mx1\n\tmx mx2\n}\nbackup {\n\tns ns3\n}\n"
+	root, err := figtree.ParseString(input, figtree.WithStrict())
+	if err != nil {
+		t.Fatalf("expected 'nil', got '%v'", err)
+	}
+	nameServers, _ := root.GetBranch("name-servers")
+	if nameServers == nil || !nameServers.ItemIsArray("ns") {
+		t.Errorf("expected 'name-servers/ns' to be an array")
+	}
+	wf := figtree.WriteFigtree{}
+	buf, _ := root.WriteToBuffer(wf)
+	if input != buf {
+		t.Errorf("expected\n%s\ngot\n%s", input, buf)
+	}
+
+	// keys ending in [] are arrays, as they are for the writers
+	input = "ns[] ns1\nns[] ns2\n"
+	root, err = figtree.ParseString(input, figtree.WithStrict())
+	if err != nil || !root.ItemIsArray("ns[]") {
+		t.Errorf("expected 'ns[]' to be an array, got '%v'", err)
+	}
+}
+
+func TestStrictTolerant(t *testing.T) {
+	input := "port 80\nport 8080\n!unknown value\nhost localhost\n"
+	root, diagnostics := figtree.ReadFigtreeFromTolerant(strings.NewReader(input), "string", figtree.WithStrict())
+	expected := []string{
+		"string:2:1: error: duplicate key for 'port'",
+		"string:3:1: error: unknown pragma for '!unknown'",
+	}
+	actual := make([]string, 0)
+	for _, diagnostic := range diagnostics {
+		actual = append(actual, diagnostic.String())
+	}
+	if strings.Join(expected, "\n") != strings.Join(actual, "\n") {
+		t.Errorf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+	if root.ItemCount() != 4 {
+		t.Errorf("expected 4 items to be kept, got %d", root.ItemCount())
+	}
+}
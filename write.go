@@ -31,7 +31,16 @@ type WriteConfig interface {
 
 // The WriteFigtree type is used with WriteToFile and WriteToBuffer to
 // serialize a configuration using native figtree syntax.
-type WriteFigtree struct{}
+//
+// When QuotedValues is true, values that would not otherwise be read back verbatim are
+// delimited by quotation marks, and the file should be read with the WithQuotedValues option.
+// When EnvExpansion is true, the dollar signs of values set with NewItem or SetValue are doubled,
+// so that they are not expanded when the file is read with the WithEnvExpansion option.
+// Otherwise values are written as they are.
+type WriteFigtree struct {
+	QuotedValues bool
+	EnvExpansion bool
+}
 
 // The WriteInternal type is used with WriteToFile and WriteToBuffer to
 // serialize a configuration with internal parsing and debugging information.
@@ -101,11 +110,42 @@ func (branch *Branch) WriteToBuffer(wc WriteConfig) (string, error) {
 // The depth parameter specifies how many tab characters to indent each line.
 // This function is typically only called by WriteToFile or WriteToBuffer.
 func (wf WriteFigtree) serializeConfig(branch *Branch, w *bufio.Writer, depth int) error {
+	// reproduce the "\r\n" line endings of the file that the tree was read from
+	if branch.lineEnding == "\r\n" {
+		crlf := bufio.NewWriter(crlfWriter{w})
+		lfBranch := *branch
+		lfBranch.lineEnding = "\n"
+		err := wf.serializeConfig(&lfBranch, crlf, depth)
+		if err != nil {
+			return err
+		}
+		return crlf.Flush()
+	}
+	return wf.serializeItems(branch, nil, false, w, depth)
+}
+
+// Write the items of the branch that belong to the given taken conditional block, or, when the
+// block is nil, the items that don't belong to any. A taken block is written with the copies of
+// its items that were added to the branch enclosing it, rather than with the originals, so that
+// any change made to the copies is written. Outside of a profile block, the items supplied by a
+// selected profile are written as the items they replaced, since the profile block is written too.
+func (wf WriteFigtree) serializeItems(branch *Branch, block *Branch, inProfile bool, w *bufio.Writer, depth int) error {
 	prefix := strings.Repeat("\t", depth)
 	var err error
 
-	for _, item := range branch.Items {
+	items := branch.Items
+	if !inProfile {
+		items = unprofiledItems(items)
+	}
+	for _, item := range items {
 		key := item.key
+		if item.mergeAppend {
+			key = "+" + key
+		}
+
+		if item.conditional != block {
+			continue
+		}
 
 		// write any blank lines or block comments
 		for _, bc := range item.blockComments {
@@ -121,19 +161,44 @@ func (wf WriteFigtree) serializeConfig(branch *Branch, w *bufio.Writer, depth in
 		}
 
 		switch value := item.value.(type) {
-		// simple key/value pair
+		// simple key/value pair, using the raw value rather than any expanded variables,
+		// as a heredoc when it spans multiple lines, or quoted when it would not otherwise be read back verbatim
 		case string:
+			if item.rawValue != "" {
+				value = item.rawValue
+			}
+			if delimiter := heredocDelimiter(value); delimiter != "" {
+				err = wf.serializeHeredoc(key, value, delimiter, wsComment, w, prefix)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			// a value set in code is written so that it reads back the same, rather than being expanded
+			if wf.EnvExpansion && item.literalValue && !strings.HasPrefix(item.key, "!") && hasVariables(value) {
+				value = strings.ReplaceAll(value, "$", "$$")
+			}
+			if wf.QuotedValues && valueNeedsQuotes(value) {
+				value = quoteValue(value)
+			}
 			_, err = fmt.Fprintf(w, "%s%s %s%s\n", prefix, key, value, wsComment)
 			if err != nil {
 				return err
 			}
-		// nested branch
+		// nested branch, or a taken conditional block
 		case *Branch:
 			_, err = fmt.Fprintf(w, "%s%s {%s\n", prefix, key, wsComment)
 			if err != nil {
 				return err
 			}
-			_ = wf.serializeConfig(value, w, depth+1)
+			if item.conditionalTaken {
+				err = wf.serializeItems(branch, value, inProfile, w, depth+1)
+			} else {
+				err = wf.serializeItems(value, nil, inProfile || isProfileBlock(item), w, depth+1)
+			}
+			if err != nil {
+				return err
+			}
 			_, err = fmt.Fprintf(w, "%s}\n", prefix)
 			if err != nil {
 				return err
@@ -144,6 +209,28 @@ func (wf WriteFigtree) serializeConfig(branch *Branch, w *bufio.Writer, depth in
 	return nil
 }
 
+// Write a multi-line value as an indented heredoc. Each line of the value is indented
+// one tab deeper than the key, and the closing delimiter is indented to match, so that
+// the indentation is stripped when the heredoc is read back.
+func (wf WriteFigtree) serializeHeredoc(key string, value string, delimiter string, wsComment string, w *bufio.Writer, prefix string) error {
+	_, err := fmt.Fprintf(w, "%s%s <<-%s%s\n", prefix, key, delimiter, wsComment)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(value, "\n") {
+		if line == "" {
+			_, err = fmt.Fprintln(w)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\t%s\n", prefix, line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "%s\t%s\n", prefix, delimiter)
+	return err
+}
+
 //-----------------------------------------------------------------------------
 // Write Internal
 //-----------------------------------------------------------------------------
@@ -157,9 +244,11 @@ func (wi WriteInternal) serializeConfig(branch *Branch, w *bufio.Writer, depth i
 
 	for _, item := range branch.Items {
 		key := item.key
+		if item.mergeAppend {
+			key = "+" + key
+		}
 
-		srcContext := fmt.Sprintf("(%v)[%s:%d]", item.srcOrigin, filepath.Base(item.srcFile), item.srcLine)
-		srcContext = fmt.Sprintf("%-32s%s ", srcContext, prefix)
+		srcContext := fmt.Sprintf("%-32s%s ", internalSource(item), prefix)
 
 		// write any blank lines or block comments
 		for _, bc := range item.blockComments {
@@ -175,8 +264,19 @@ func (wi WriteInternal) serializeConfig(branch *Branch, w *bufio.Writer, depth i
 		}
 
 		switch value := item.value.(type) {
-		// simple key/value pair
+		// simple key/value pair, showing the raw value rather than any expanded variables,
+		// quoted when it can't be shown on a single line
 		case string:
+			if item.rawValue != "" {
+				value = item.rawValue
+			}
+			if containsControlCharacter(value) {
+				value = quoteValue(value)
+			}
+			// show the source of each item removed by an !unset item
+			for _, removedItem := range item.removed {
+				value += fmt.Sprintf(" (removed %s)", internalSource(removedItem))
+			}
 			_, err = fmt.Fprintf(w, "%s%s %s%s\n", srcContext, key, value, wsComment)
 			if err != nil {
 				return err
@@ -201,6 +301,16 @@ func (wi WriteInternal) serializeConfig(branch *Branch, w *bufio.Writer, depth i
 	return nil
 }
 
+// Format the origin, file, and line of an item, such as "(Base)[baseline:12]",
+// together with the profile that supplied the item, if any.
+func internalSource(item Item) string {
+	origin := item.srcOrigin.String()
+	if item.profile != "" {
+		origin += ":" + item.profile
+	}
+	return fmt.Sprintf("(%s)[%s:%d]", origin, filepath.Base(item.srcFile), item.srcLine)
+}
+
 //-----------------------------------------------------------------------------
 // Write JSON
 //-----------------------------------------------------------------------------
@@ -240,6 +350,12 @@ func (wj WriteJson) serializeBranch(branch *Branch, w *bufio.Writer, depth int)
 			continue
 		}
 
+		// a conditional block is only figtree syntax, its taken items having been copied alongside it,
+		// as is a profile block, a selected profile having been merged over its enclosing branch
+		if isConditionalBlock(item) || isProfileBlock(item) {
+			continue
+		}
+
 		// check to see if the keyname ends in [], if so, treat it as an array even if it is empty or has only one entry
 		bracketPos := strings.Index(key, "[]")
 		bIsArray := false
@@ -348,22 +464,22 @@ func (wj WriteJson) serializeArray(keyName string, allItems []Item, branch *Bran
 	return nil
 }
 
-// \b   U+0008 backspace
-// \f   U+000C form feed
-// \n   U+000A line feed or newline
-// \r   U+000D carriage return
-// \t   U+0009 horizontal tab
-// \v   U+000B vertical tab
-// \"   U+0022 double quote
-// \\   U+005C reverse solidus
+// \b       U+0008 backspace
+// \f       U+000C form feed
+// \n       U+000A line feed or newline
+// \r       U+000D carriage return
+// \t       U+0009 horizontal tab
+// \u000B   U+000B vertical tab, which has no short escape in JSON
+// \"       U+0022 double quote
+// \\       U+005C reverse solidus
 func escapeJsonKey(unescaped string) string {
 	r := strings.NewReplacer(
-		"\u0008", "\b",
-		"\u000C", "\f",
-		"\u000A", "\n",
-		"\u000D", "\r",
-		"\u0009", "\t",
-		"\u000B", "\v",
+		"\u0008", `\b`,
+		"\u000C", `\f`,
+		"\u000A", `\n`,
+		"\u000D", `\r`,
+		"\u0009", `\t`,
+		"\u000B", `\u000B`,
 		"\u0022", "\u005C\u0022",
 		"\u005C", "\u005C\u005C")
 	return r.Replace(unescaped)
@@ -423,6 +539,12 @@ func (wy WriteYaml) serializeBranch(branch *Branch, w *bufio.Writer, depth int)
 			continue
 		}
 
+		// a conditional block is only figtree syntax, its taken items having been copied alongside it,
+		// as is a profile block, a selected profile having been merged over its enclosing branch
+		if isConditionalBlock(item) || isProfileBlock(item) {
+			continue
+		}
+
 		// write any blank lines or block comments
 		for _, bc := range item.blockComments {
 			_, err = fmt.Fprintln(w, prefix+bc)
@@ -541,8 +663,9 @@ func (wy WriteYaml) serializeArray(keyName string, allItems []Item, branch *Bran
 // \t   U+0009 horizontal tab
 // \v   U+000B vertical tab
 // \"   U+0022 double quote
-// \'   U+0027 apostrophe
 // \\   U+005C reverse solidus
+// An apostrophe needs no escape within double quotes, but the value is still delimited,
+// so that one at the beginning of the value is not read as a single-quoted scalar.
 func escapeYaml(unescaped string) string {
 	if len(unescaped) == 0 {
 		return "null "
@@ -554,19 +677,18 @@ func escapeYaml(unescaped string) string {
 	}
 
 	bNeedsDelimiter := false
-	if strings.ContainsAny(unescaped, "-?:,[]{}#&*!|>`") {
+	if strings.ContainsAny(unescaped, "-?:,[]{}#&*!|>`'") {
 		bNeedsDelimiter = true
 	}
 
 	r := strings.NewReplacer(
-		"\u0008", "\b",
-		"\u000C", "\f",
-		"\u000A", "\n",
-		"\u000D", "\r",
-		"\u0009", "\t",
-		"\u000B", "\v",
+		"\u0008", `\b`,
+		"\u000C", `\f`,
+		"\u000A", `\n`,
+		"\u000D", `\r`,
+		"\u0009", `\t`,
+		"\u000B", `\v`,
 		"\u0022", "\u005C\u0022",
-		"\u0027", "\u005C\u0027",
 		"\u005C", "\u005C\u005C")
 	escaped := r.Replace(unescaped)
 